@@ -2,12 +2,15 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/config"
 	"go.uber.org/zap"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"strings"
 	"time"
@@ -26,7 +29,7 @@ type PanelRequest struct {
 
 // Builds the base request instance that can be used with the HTTP client.
 func (r *PanelRequest) GetClient() *http.Client {
-	return &http.Client{Timeout: time.Second * 30}
+	return &http.Client{Timeout: time.Second * time.Duration(config.Get().RemoteQuery.Timeout)}
 }
 
 func (r *PanelRequest) SetHeaders(req *http.Request) *http.Request {
@@ -45,34 +48,132 @@ func (r *PanelRequest) GetEndpoint(endpoint string) string {
 	)
 }
 
-func (r *PanelRequest) Get(url string) (*http.Response, error) {
+func (r *PanelRequest) Get(ctx context.Context, url string) (*http.Response, error) {
+	return r.Do(ctx, http.MethodGet, url, nil, true)
+}
+
+func (r *PanelRequest) Post(ctx context.Context, url string, data []byte) (*http.Response, error) {
+	return r.Do(ctx, http.MethodPost, url, data, false)
+}
+
+// Sends a request to the Panel. If the request is idempotent it will be retried using an
+// exponential backoff when the Panel cannot be reached or responds that it is unavailable.
+//
+// Requests are rejected without being sent if too many consecutive requests to the Panel
+// have failed, this can be checked for using IsCircuitOpenError. If the context is cancelled
+// the request is aborted, including while waiting to retry it.
+func (r *PanelRequest) Do(ctx context.Context, method string, url string, data []byte, idempotent bool) (*http.Response, error) {
+	cfg := config.Get().RemoteQuery
+	b := breakerFor(config.Get().PanelLocation)
+
+	attempts := 1
+	if idempotent {
+		attempts += cfg.Retries
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := b.Allow(time.Second * time.Duration(cfg.Cooldown)); err != nil {
+			return nil, err
+		}
+
+		resp, err := r.send(ctx, method, url, data)
+
+		// A request that was aborted because the caller cancelled it says nothing about the
+		// state of the Panel, so return right away without counting it as a failure.
+		if ctx.Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			b.Release()
+
+			return nil, ctx.Err()
+		}
+
+		if err != nil || IsUnavailableStatus(resp.StatusCode) {
+			b.Failure(cfg.FailureThreshold)
+		} else {
+			b.Success()
+		}
+
+		if (err == nil && !IsUnavailableStatus(resp.StatusCode)) || attempt >= attempts {
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		delay := retryDelay(cfg, attempt)
+
+		zap.S().Debugw(
+			"request to panel failed, retrying",
+			zap.String("method", method),
+			zap.String("endpoint", r.GetEndpoint(url)),
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+func (r *PanelRequest) send(ctx context.Context, method string, url string, data []byte) (*http.Response, error) {
 	c := r.GetClient()
 
-	req, err := http.NewRequest(http.MethodGet, r.GetEndpoint(url), nil)
-	req = r.SetHeaders(req)
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
 
+	req, err := http.NewRequest(method, r.GetEndpoint(url), body)
 	if err != nil {
 		return nil, err
 	}
 
-	zap.S().Debugw("GET request to endpoint", zap.String("endpoint", r.GetEndpoint(url)), zap.Any("headers", req.Header))
+	req = r.SetHeaders(req.WithContext(ctx))
+
+	zap.S().Debugw(method+" request to endpoint", zap.String("endpoint", r.GetEndpoint(url)), zap.Any("headers", req.Header))
 
 	return c.Do(req)
 }
 
-func (r *PanelRequest) Post(url string, data []byte) (*http.Response, error) {
-	c := r.GetClient()
+// Determines if a response status indicates that the Panel is currently unable to handle
+// requests, rather than the request itself being invalid. Requests that receive one of
+// these are retried and count towards opening the circuit breaker, and callers can fall
+// back to data they have cached from the Panel.
+func IsUnavailableStatus(status int) bool {
+	return status >= 500 || status == http.StatusTooManyRequests
+}
 
-	req, err := http.NewRequest(http.MethodPost, r.GetEndpoint(url), bytes.NewBuffer(data))
-	req = r.SetHeaders(req)
+// Returns the amount of time to wait before retrying a failed request. The delay doubles
+// with each attempt up to the configured maximum, and half of it is randomized so that
+// many servers retrying at once do not all hit the Panel at the same moment.
+func retryDelay(cfg config.RemoteQueryConfiguration, attempt int) time.Duration {
+	d := time.Millisecond * time.Duration(cfg.RetryDelay)
+	max := time.Millisecond * time.Duration(cfg.MaxRetryDelay)
 
-	if err != nil {
-		return nil, err
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
 	}
 
-	zap.S().Debugw("POST request to endpoint", zap.String("endpoint", r.GetEndpoint(url)), zap.Any("headers", req.Header))
+	if d > max {
+		d = max
+	}
 
-	return c.Do(req)
+	if d <= 0 {
+		return 0
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
 }
 
 // Determines if the API call encountered an error. If no request has been made
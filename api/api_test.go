@@ -0,0 +1,239 @@
+package api_test
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/sftp-server"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/paneltest"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testServerUuid = "0f2d5cf6-7bd4-4b0e-9a4c-8f4c5e1a2b3c"
+
+// Starts a fake Panel and points the global configuration at it. The returned function
+// must be called once the test is finished to stop the panel and restore the previous
+// configuration.
+func newPanel(t *testing.T) (*paneltest.Panel, func()) {
+	t.Helper()
+
+	p := paneltest.New("test-token")
+	previous := config.Get()
+	config.Set(p.Configuration())
+
+	return p, func() {
+		config.Set(previous)
+		p.Close()
+	}
+}
+
+// Reads a recorded Panel response from the testdata directory.
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestRequestsAreAuthenticated(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("GET", "/servers/"+testServerUuid, 200, fixture(t, "server_configuration.json"))
+
+	if _, rerr, err := api.NewRequester().GetServerConfiguration(context.Background(), testServerUuid); err != nil || rerr != nil {
+		t.Fatalf("unexpected error: %v %v", err, rerr)
+	}
+
+	r, ok := p.LastRequest("GET", "/servers/"+testServerUuid)
+	if !ok {
+		t.Fatal("expected a request to be made to the panel")
+	}
+
+	if h := r.Header.Get("Authorization"); h != "Bearer test-token" {
+		t.Errorf("expected bearer token to be sent, got %q", h)
+	}
+
+	if h := r.Header.Get("Accept"); h != paneltest.AcceptHeader {
+		t.Errorf("expected accept header %q, got %q", paneltest.AcceptHeader, h)
+	}
+}
+
+func TestInvalidTokenReturnsErrorBag(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("GET", "/servers/"+testServerUuid, 200, fixture(t, "server_configuration.json"))
+	p.Token = "another-token"
+
+	_, rerr, err := api.NewRequester().GetServerConfiguration(context.Background(), testServerUuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rerr == nil || rerr.Status != "403" || rerr.Code != "AccessDeniedHttpException" {
+		t.Fatalf("expected an access denied error, got %+v", rerr)
+	}
+}
+
+func TestGetRetriesWhenPanelUnavailable(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Queue("GET", "/servers/"+testServerUuid, 503, nil)
+	p.Queue("GET", "/servers/"+testServerUuid, 502, nil)
+	p.Handle("GET", "/servers/"+testServerUuid, 200, fixture(t, "server_configuration.json"))
+
+	if _, rerr, err := api.NewRequester().GetServerConfiguration(context.Background(), testServerUuid); err != nil || rerr != nil {
+		t.Fatalf("unexpected error: %v %v", err, rerr)
+	}
+
+	if n := len(p.Requests()); n != 3 {
+		t.Errorf("expected 3 requests to be made, got %d", n)
+	}
+}
+
+func TestClientErrorsAreNotRetried(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.HandleError("GET", "/servers/"+testServerUuid, 404, "NotFoundHttpException", "The requested server does not exist.")
+
+	if _, rerr, _ := api.NewRequester().GetServerConfiguration(context.Background(), testServerUuid); rerr == nil {
+		t.Fatal("expected an error to be returned")
+	}
+
+	if n := len(p.Requests()); n != 1 {
+		t.Errorf("expected 1 request to be made, got %d", n)
+	}
+}
+
+func TestNonIdempotentRequestsAreNotRetried(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("POST", "/sftp/auth", 503, nil)
+
+	if _, err := api.NewRequester().ValidateSftpCredentials(context.Background(), sftp_server.AuthenticationRequest{}); err == nil {
+		t.Fatal("expected an error to be returned")
+	}
+
+	if n := len(p.Requests()); n != 1 {
+		t.Errorf("expected 1 request to be made, got %d", n)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("GET", "/servers/"+testServerUuid, 503, nil)
+
+	cfg := config.Get()
+	r := api.NewRequester()
+
+	// The first request uses all of its attempts, and the second trips the breaker once
+	// the failure threshold is reached.
+	for i := 0; i < 2; i++ {
+		r.GetServerConfiguration(context.Background(), testServerUuid)
+	}
+
+	if n := len(p.Requests()); n != cfg.RemoteQuery.FailureThreshold {
+		t.Errorf("expected %d requests to be made, got %d", cfg.RemoteQuery.FailureThreshold, n)
+	}
+
+	if _, _, err := r.GetServerConfiguration(context.Background(), testServerUuid); !api.IsCircuitOpenError(err) {
+		t.Fatalf("expected requests to be rejected, got %v", err)
+	}
+
+	if n := len(p.Requests()); n != cfg.RemoteQuery.FailureThreshold {
+		t.Errorf("expected no further requests to be made, got %d", n-cfg.RemoteQuery.FailureThreshold)
+	}
+
+	// Once the cooldown has elapsed a request is let through, and the breaker is closed
+	// if it succeeds.
+	cfg.RemoteQuery.Cooldown = 0
+	p.Handle("GET", "/servers/"+testServerUuid, 200, fixture(t, "server_configuration.json"))
+
+	for i := 0; i < 2; i++ {
+		if _, rerr, err := r.GetServerConfiguration(context.Background(), testServerUuid); err != nil || rerr != nil {
+			t.Fatalf("unexpected error: %v %v", err, rerr)
+		}
+	}
+}
+
+func TestCancelledRequestsAreNotRetried(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("GET", "/servers/"+testServerUuid, 503, nil)
+
+	// Use a long delay so that the request is still waiting to be retried when the
+	// context is cancelled.
+	config.Get().RemoteQuery.RetryDelay = 60000
+	config.Get().RemoteQuery.MaxRetryDelay = 60000
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+
+	_, _, err := api.NewRequester().GetServerConfiguration(ctx, testServerUuid)
+	if errors.Cause(err) != context.Canceled {
+		t.Fatalf("expected the request to be cancelled, got %v", err)
+	}
+
+	if n := len(p.Requests()); n != 1 {
+		t.Errorf("expected 1 request to be made, got %d", n)
+	}
+}
+
+func TestCancelledProbeDoesNotLeaveBreakerOpen(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("GET", "/servers/"+testServerUuid, 500, nil)
+
+	cfg := config.Get()
+	cfg.RemoteQuery.Retries = 0
+	r := api.NewRequester()
+
+	for i := 0; i < cfg.RemoteQuery.FailureThreshold; i++ {
+		r.GetServerConfiguration(context.Background(), testServerUuid)
+	}
+
+	if _, _, err := r.GetServerConfiguration(context.Background(), testServerUuid); !api.IsCircuitOpenError(err) {
+		t.Fatalf("expected server errors to open the breaker, got %v", err)
+	}
+
+	// The request let through once the cooldown has elapsed is cancelled before the Panel
+	// responds, so the next request must be let through in its place.
+	cfg.RemoteQuery.Cooldown = 0
+	p.Handle("GET", "/servers/"+testServerUuid, 200, fixture(t, "server_configuration.json"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, _, err := r.GetServerConfiguration(ctx, testServerUuid); errors.Cause(err) != context.Canceled {
+		t.Fatalf("expected the request to be cancelled, got %v", err)
+	}
+
+	if _, rerr, err := r.GetServerConfiguration(context.Background(), testServerUuid); err != nil || rerr != nil {
+		t.Fatalf("unexpected error: %v %v", err, rerr)
+	}
+}
+
+func TestIsUnavailableStatus(t *testing.T) {
+	for status, expected := range map[int]bool{
+		200: false,
+		404: false,
+		422: false,
+		429: true,
+		500: true,
+		502: true,
+		503: true,
+		504: true,
+	} {
+		if api.IsUnavailableStatus(status) != expected {
+			t.Errorf("expected status %d to be unavailable=%t", status, expected)
+		}
+	}
+}
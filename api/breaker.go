@@ -0,0 +1,107 @@
+package api
+
+import (
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// Tracks failed requests to a Panel so that requests can be rejected immediately while
+// the Panel is unavailable, rather than each one waiting on the request timeout.
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+var breakers = struct {
+	sync.Mutex
+	m map[string]*circuitBreaker
+}{m: make(map[string]*circuitBreaker)}
+
+// Returns the circuit breaker for a Panel location, creating it if it does not exist.
+func breakerFor(location string) *circuitBreaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	b, ok := breakers.m[location]
+	if !ok {
+		b = &circuitBreaker{}
+		breakers.m[location] = b
+	}
+
+	return b
+}
+
+// Determines if a request should be sent to the Panel. Once the cooldown has elapsed for
+// an open breaker a single request is let through; the result of that request decides if
+// the breaker is closed again.
+func (b *circuitBreaker) Allow(cooldown time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < cooldown {
+			return &circuitOpen{retryAt: b.openedAt.Add(cooldown)}
+		}
+
+		b.state = breakerHalfOpen
+
+		return nil
+	case breakerHalfOpen:
+		return &circuitOpen{retryAt: time.Now().Add(cooldown)}
+	}
+
+	return nil
+}
+
+// Records a request that was aborted before the Panel responded to it, which says nothing
+// about the state of the Panel. If this was the request let through to check if the Panel
+// has recovered, the breaker is opened again so that the next request is let through
+// in its place.
+func (b *circuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+// Records a request that the Panel responded to.
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state != breakerClosed {
+		zap.S().Infow("panel is responding to requests again, resuming normal operation")
+	}
+
+	b.state = breakerClosed
+	b.failures = 0
+}
+
+// Records a request that failed because the Panel could not be reached or is unavailable.
+func (b *circuitBreaker) Failure(threshold int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= threshold) {
+		zap.S().Warnw("panel is not responding to requests, rejecting new requests temporarily", zap.Int("failures", b.failures))
+
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
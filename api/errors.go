@@ -0,0 +1,23 @@
+package api
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"time"
+)
+
+type circuitOpen struct {
+	retryAt time.Time
+}
+
+func (e *circuitOpen) Error() string {
+	return fmt.Sprintf("panel is currently unavailable, requests will resume at %s", e.retryAt.Format(time.RFC3339))
+}
+
+// Determines if an error was returned because requests to the Panel are being rejected
+// after too many consecutive failures.
+func IsCircuitOpenError(err error) bool {
+	_, ok := errors.Cause(err).(*circuitOpen)
+
+	return ok
+}
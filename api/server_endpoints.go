@@ -1,10 +1,12 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/parser"
+	"net/http"
 )
 
 const (
@@ -50,8 +52,8 @@ type InstallationScript struct {
 }
 
 // Fetches the server configuration and returns the struct for it.
-func (r *PanelRequest) GetServerConfiguration(uuid string) (*ServerConfigurationResponse, *RequestError, error) {
-	resp, err := r.Get(fmt.Sprintf("/servers/%s", uuid))
+func (r *PanelRequest) GetServerConfiguration(ctx context.Context, uuid string) (*ServerConfigurationResponse, *RequestError, error) {
+	resp, err := r.Get(ctx, fmt.Sprintf("/servers/%s", uuid))
 	if err != nil {
 		return nil, nil, errors.WithStack(err)
 	}
@@ -74,10 +76,10 @@ func (r *PanelRequest) GetServerConfiguration(uuid string) (*ServerConfiguration
 }
 
 // Fetches installation information for the server process.
-func (r *PanelRequest) GetInstallationScript(uuid string) (InstallationScript, *RequestError, error) {
+func (r *PanelRequest) GetInstallationScript(ctx context.Context, uuid string) (InstallationScript, *RequestError, error) {
 	res := InstallationScript{}
 
-	resp, err := r.Get(fmt.Sprintf("/servers/%s/install", uuid))
+	resp, err := r.Get(ctx, fmt.Sprintf("/servers/%s/install", uuid))
 	if err != nil {
 		return res, nil, errors.WithStack(err)
 	}
@@ -103,13 +105,15 @@ type installRequest struct {
 }
 
 // Marks a server as being installed successfully or unsuccessfully on the panel.
-func (r *PanelRequest) SendInstallationStatus(uuid string, successful bool) (*RequestError, error) {
+func (r *PanelRequest) SendInstallationStatus(ctx context.Context, uuid string, successful bool) (*RequestError, error) {
 	b, err := json.Marshal(installRequest{Successful: successful})
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	resp, err := r.Post(fmt.Sprintf("/servers/%s/install", uuid), b)
+	// Sending the same installation status more than once has no additional effect on the
+	// Panel, so this request can safely be retried.
+	resp, err := r.Do(ctx, http.MethodPost, fmt.Sprintf("/servers/%s/install", uuid), b, true)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
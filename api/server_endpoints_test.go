@@ -0,0 +1,172 @@
+package api_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pterodactyl/wings/api"
+	"testing"
+)
+
+func TestGetServerConfiguration(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("GET", "/servers/"+testServerUuid, 200, fixture(t, "server_configuration.json"))
+
+	res, rerr, err := api.NewRequester().GetServerConfiguration(context.Background(), testServerUuid)
+	if err != nil || rerr != nil {
+		t.Fatalf("unexpected error: %v %v", err, rerr)
+	}
+
+	var settings struct {
+		Uuid       string `json:"uuid"`
+		Invocation string `json:"invocation"`
+	}
+	if err := json.Unmarshal(res.Settings, &settings); err != nil {
+		t.Fatal(err)
+	}
+
+	if settings.Uuid != testServerUuid {
+		t.Errorf("expected settings for %s, got %s", testServerUuid, settings.Uuid)
+	}
+
+	if settings.Invocation == "" {
+		t.Error("expected the server invocation to be present in the settings")
+	}
+
+	pc := res.ProcessConfiguration
+	if pc == nil {
+		t.Fatal("expected process configuration to be present")
+	}
+
+	if pc.Startup.Done != ")! For help, type " {
+		t.Errorf("unexpected startup done value: %q", pc.Startup.Done)
+	}
+
+	if len(pc.Startup.UserInteraction) != 1 {
+		t.Errorf("expected one user interaction line, got %d", len(pc.Startup.UserInteraction))
+	}
+
+	if pc.Stop.Type != api.ProcessStopCommand || pc.Stop.Value != "stop" {
+		t.Errorf("unexpected stop configuration: %+v", pc.Stop)
+	}
+
+	if len(pc.ConfigurationFiles) != 1 {
+		t.Fatalf("expected one configuration file, got %d", len(pc.ConfigurationFiles))
+	}
+
+	f := pc.ConfigurationFiles[0]
+	if f.FileName != "server.properties" || f.Parser != "properties" || len(f.Replace) != 2 {
+		t.Errorf("unexpected configuration file: %+v", f)
+	}
+}
+
+func TestGetServerConfigurationNotFound(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.HandleError("GET", "/servers/"+testServerUuid, 404, "NotFoundHttpException", "The requested server does not exist.")
+
+	res, rerr, err := api.NewRequester().GetServerConfiguration(context.Background(), testServerUuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res != nil {
+		t.Errorf("expected no configuration to be returned, got %+v", res)
+	}
+
+	// The server package relies on the status being "404" to determine that a server
+	// no longer exists on the Panel.
+	if rerr == nil || rerr.Status != "404" {
+		t.Fatalf("expected a not found error, got %+v", rerr)
+	}
+
+	if s := rerr.String(); s != "NotFoundHttpException: The requested server does not exist. (HTTP/404)" {
+		t.Errorf("unexpected error string: %s", s)
+	}
+}
+
+func TestGetServerConfigurationMalformedResponse(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("GET", "/servers/"+testServerUuid, 200, "<html></html>")
+
+	if _, rerr, err := api.NewRequester().GetServerConfiguration(context.Background(), testServerUuid); err == nil || rerr != nil {
+		t.Fatalf("expected a decoding error, got %v %v", err, rerr)
+	}
+}
+
+func TestGetInstallationScript(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("GET", "/servers/"+testServerUuid+"/install", 200, fixture(t, "installation_script.json"))
+
+	script, rerr, err := api.NewRequester().GetInstallationScript(context.Background(), testServerUuid)
+	if err != nil || rerr != nil {
+		t.Fatalf("unexpected error: %v %v", err, rerr)
+	}
+
+	if script.ContainerImage != "alpine:3.4" || script.Entrypoint != "ash" {
+		t.Errorf("unexpected installation script: %+v", script)
+	}
+
+	if script.Script == "" {
+		t.Error("expected the installation script contents to be present")
+	}
+}
+
+func TestGetInstallationScriptError(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.HandleError("GET", "/servers/"+testServerUuid+"/install", 500, "HttpException", "An unexpected error was encountered.")
+
+	_, rerr, err := api.NewRequester().GetInstallationScript(context.Background(), testServerUuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rerr == nil || rerr.Status != "500" || rerr.Code != "HttpException" {
+		t.Fatalf("expected a server error, got %+v", rerr)
+	}
+}
+
+func TestSendInstallationStatus(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("POST", "/servers/"+testServerUuid+"/install", 204, nil)
+
+	for _, successful := range []bool{true, false} {
+		rerr, err := api.NewRequester().SendInstallationStatus(context.Background(), testServerUuid, successful)
+		if err != nil || rerr != nil {
+			t.Fatalf("unexpected error: %v %v", err, rerr)
+		}
+
+		r, ok := p.LastRequest("POST", "/servers/"+testServerUuid+"/install")
+		if !ok {
+			t.Fatal("expected installation status to be sent to the panel")
+		}
+
+		var body map[string]interface{}
+		if err := r.Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(body) != 1 || body["successful"] != successful {
+			t.Errorf("unexpected installation status payload: %s", r.Body)
+		}
+	}
+}
+
+func TestSendInstallationStatusError(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.HandleError("POST", "/servers/"+testServerUuid+"/install", 422, "ValidationException", "The successful field is required.")
+
+	rerr, err := api.NewRequester().SendInstallationStatus(context.Background(), testServerUuid, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rerr == nil || rerr.Status != "422" || rerr.Detail != "The successful field is required." {
+		t.Fatalf("expected a validation error, got %+v", rerr)
+	}
+}
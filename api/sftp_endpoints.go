@@ -1,18 +1,19 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/sftp-server"
 )
 
-func (r *PanelRequest) ValidateSftpCredentials(request sftp_server.AuthenticationRequest) (*sftp_server.AuthenticationResponse, error) {
+func (r *PanelRequest) ValidateSftpCredentials(ctx context.Context, request sftp_server.AuthenticationRequest) (*sftp_server.AuthenticationResponse, error) {
 	b, err := json.Marshal(request)
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := r.Post("/sftp/auth", b)
+	resp, err := r.Post(ctx, "/sftp/auth", b)
 	if err != nil {
 		return nil, err
 	}
@@ -22,7 +23,7 @@ func (r *PanelRequest) ValidateSftpCredentials(request sftp_server.Authenticatio
 
 	if r.HasError() {
 		if r.HttpResponseCode() == 403 {
-			return nil, sftp_server.InvalidCredentialsError{}
+			return nil, &sftp_server.InvalidCredentialsError{}
 		}
 
 		return nil, errors.WithStack(errors.New(r.Error().String()))
@@ -0,0 +1,66 @@
+package api_test
+
+import (
+	"context"
+	"github.com/pterodactyl/sftp-server"
+	"github.com/pterodactyl/wings/api"
+	"testing"
+)
+
+func TestValidateSftpCredentials(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.Handle("POST", "/sftp/auth", 200, fixture(t, "sftp_auth.json"))
+
+	res, err := api.NewRequester().ValidateSftpCredentials(context.Background(), sftp_server.AuthenticationRequest{
+		User: "user.0f2d5cf6",
+		Pass: "password",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Server != testServerUuid || res.Token != "sftp-session-token" || len(res.Permissions) != 2 {
+		t.Errorf("unexpected authentication response: %+v", res)
+	}
+
+	r, ok := p.LastRequest("POST", "/sftp/auth")
+	if !ok {
+		t.Fatal("expected credentials to be sent to the panel")
+	}
+
+	var body map[string]string
+	if err := r.Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body) != 2 || body["username"] != "user.0f2d5cf6" || body["password"] != "password" {
+		t.Errorf("unexpected authentication payload: %s", r.Body)
+	}
+}
+
+func TestValidateSftpCredentialsInvalid(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.HandleError("POST", "/sftp/auth", 403, "AccessDeniedHttpException", "The credentials provided were invalid.")
+
+	_, err := api.NewRequester().ValidateSftpCredentials(context.Background(), sftp_server.AuthenticationRequest{User: "user", Pass: "invalid"})
+	if !sftp_server.IsInvalidCredentialsError(err) {
+		t.Fatalf("expected invalid credentials error, got %v", err)
+	}
+}
+
+func TestValidateSftpCredentialsError(t *testing.T) {
+	p, cleanup := newPanel(t)
+	defer cleanup()
+	p.HandleError("POST", "/sftp/auth", 500, "HttpException", "An unexpected error was encountered.")
+
+	_, err := api.NewRequester().ValidateSftpCredentials(context.Background(), sftp_server.AuthenticationRequest{User: "user", Pass: "password"})
+	if err == nil || sftp_server.IsInvalidCredentialsError(err) {
+		t.Fatalf("expected an unexpected error to be returned, got %v", err)
+	}
+
+	if err.Error() != "HttpException: An unexpected error was encountered. (HTTP/500)" {
+		t.Errorf("unexpected error message: %s", err)
+	}
+}
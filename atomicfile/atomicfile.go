@@ -0,0 +1,157 @@
+package atomicfile
+
+import (
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Writes data to the given path in a way that never leaves a partially written file
+// behind. The data is written to a temporary file in the same directory which is synced
+// to the disk and then renamed over the original, so a crash or power loss at any point
+// leaves either the old file or the new one.
+//
+// If the file already exists its permissions are kept, otherwise it is created using
+// the permissions provided.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	if st, err := os.Stat(path); err == nil {
+		perm = st.Mode().Perm()
+	} else if !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	// The temporary file is hidden and given a different extension so that it is not
+	// picked up by anything reading the directory if the daemon crashes before it has
+	// been renamed.
+	f, err := ioutil.TempFile(dir, "."+name+".*.tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := write(f, data, perm); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return err
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+
+		return errors.WithStack(err)
+	}
+
+	return syncDir(dir)
+}
+
+func write(f *os.File, data []byte, perm os.FileMode) error {
+	if _, err := f.Write(data); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := f.Chmod(perm); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(f.Close())
+}
+
+// Syncs a directory so that a file renamed into it is persisted to the disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer d.Close()
+
+	return errors.WithStack(d.Sync())
+}
+
+type pendingWrite struct {
+	render  func() ([]byte, error)
+	persist func([]byte) error
+
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// Coalesces concurrent writes to the same file. While a write is running any number of
+// new writes are grouped into a single write that runs once the current one completes,
+// using the render function from the most recent caller. Every caller waits until a write
+// that started after it was called has completed, so the data on the disk is never older
+// than the state at the time of the call.
+//
+// The zero value is ready to use.
+type Writer struct {
+	mu      sync.Mutex
+	next    *pendingWrite
+	running bool
+}
+
+// Renders the data to write by calling render, and writes it to the path using WriteFile.
+// Returns the data that was written, which may have been rendered for another caller if
+// the writes were coalesced.
+func (w *Writer) Write(path string, perm os.FileMode, render func() ([]byte, error)) ([]byte, error) {
+	return w.WriteFunc(render, func(b []byte) error {
+		return WriteFile(path, b, perm)
+	})
+}
+
+// Renders the data to write by calling render, and then passes it to persist, which is
+// responsible for saving it. This allows writes to something other than a single file,
+// such as a database, to be coalesced.
+func (w *Writer) WriteFunc(render func() ([]byte, error), persist func([]byte) error) ([]byte, error) {
+	w.mu.Lock()
+	if w.next == nil {
+		w.next = &pendingWrite{done: make(chan struct{})}
+	}
+
+	p := w.next
+	p.render = render
+	p.persist = persist
+
+	if !w.running {
+		w.running = true
+		go w.run()
+	}
+	w.mu.Unlock()
+
+	<-p.done
+
+	return p.data, p.err
+}
+
+// Runs the pending writes until there are none left.
+func (w *Writer) run() {
+	for {
+		w.mu.Lock()
+		p := w.next
+		if p == nil {
+			w.running = false
+			w.mu.Unlock()
+
+			return
+		}
+		w.next = nil
+		w.mu.Unlock()
+
+		p.data, p.err = p.render()
+		if p.err == nil {
+			p.err = p.persist(p.data)
+		}
+
+		close(p.done)
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"github.com/creasty/defaults"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/atomicfile"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
@@ -10,6 +12,7 @@ import (
 	"os/exec"
 	"os/user"
 	"path"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -49,7 +52,7 @@ type Configuration struct {
 
 		// The amount of time that should lapse between data output throttle
 		// checks. This should be defined in milliseconds.
-		CheckInterval int `defauly:"100" yaml:"check_interval"`
+		CheckInterval int `default:"100" yaml:"check_interval"`
 	}
 
 	// The location where the panel is running that this daemon should connect to
@@ -59,6 +62,67 @@ type Configuration struct {
 	// The token used when performing operations. Requests to this instance must
 	// validate aganist it.
 	AuthenticationToken string `yaml:"token"`
+
+	// Defines how requests made by the daemon to the Panel are retried when they fail.
+	RemoteQuery RemoteQueryConfiguration `yaml:"remote_query"`
+
+	// Defines how the tokens issued by the Panel for servers are validated.
+	Tokens TokenConfiguration `yaml:"tokens"`
+}
+
+// Defines the validation applied to tokens issued by the Panel for servers.
+type TokenConfiguration struct {
+	// The number of seconds that the clocks of the Panel and this daemon are allowed to
+	// differ by when checking the expiration, not before and issued at times of a token.
+	ClockSkew int `default:"30" yaml:"clock_skew"`
+
+	// If set, tokens must have been issued by this issuer.
+	Issuer string `yaml:"issuer"`
+
+	// If set, tokens must include this audience.
+	Audience string `yaml:"audience"`
+
+	// Additional keys that tokens can be signed with, selected using the "kid" header of
+	// the token. Tokens without a key ID are verified using the authentication token. This
+	// allows a new key to be added and used before the old one is removed.
+	SigningKeys []SigningKey `yaml:"signing_keys"`
+
+	// The number of seconds that a token revocation is kept for. This should be longer
+	// than the lifetime of any token issued by the Panel.
+	RevocationTTL int `default:"86400" yaml:"revocation_ttl"`
+}
+
+// A key used to sign tokens issued by the Panel.
+type SigningKey struct {
+	Id     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+}
+
+// Defines the configuration for requests made to the Panel API.
+type RemoteQueryConfiguration struct {
+	// The amount of time in seconds to wait for the Panel to respond to a single request
+	// before it is considered failed.
+	Timeout int `default:"30" yaml:"timeout"`
+
+	// The number of times an idempotent request is retried after failing due to a network
+	// error or the Panel being unavailable.
+	Retries int `default:"3" yaml:"retries"`
+
+	// The base delay in milliseconds between retries. This is doubled for each attempt
+	// and has a random jitter applied to it.
+	RetryDelay int `default:"500" yaml:"retry_delay"`
+
+	// The maximum delay in milliseconds between two retries of the same request.
+	MaxRetryDelay int `default:"10000" yaml:"max_retry_delay"`
+
+	// The number of consecutive failed requests after which requests to the Panel are
+	// rejected immediately, rather than waiting on a Panel that is likely down.
+	FailureThreshold int `default:"5" yaml:"failure_threshold"`
+
+	// The amount of time in seconds to reject requests for once the failure threshold has
+	// been reached. After this a single request is allowed through to check if the Panel
+	// has recovered.
+	Cooldown int `default:"30" yaml:"cooldown"`
 }
 
 // Defines basic system configuration settings.
@@ -102,9 +166,80 @@ type SystemConfiguration struct {
 	// the user did not press the stop button, but the process stopped cleanly.
 	DetectCleanExitAsCrash bool `default:"true" yaml:"detect_clean_exit_as_crash"`
 
+	// The amount of time in seconds that a server process is given to stop cleanly when
+	// it is being restarted. If the process is still running once this time has elapsed
+	// it will be forcibly killed before being started again.
+	StopTimeout int `default:"60" yaml:"stop_timeout"`
+
+	// The maximum amount of time in seconds that a queued power action will wait for
+	// the previous action on the same server to finish before giving up.
+	PowerLockTimeout int `default:"120" yaml:"power_lock_timeout"`
+
+	// The maximum amount of time in seconds that the daemon waits for requests and running
+	// operations, such as server installations, to finish when it is shutting down. Any
+	// operations still running once this has passed are cancelled.
+	ShutdownTimeout int `default:"30" yaml:"shutdown_timeout"`
+
+	// The number of console lines kept in memory for each server so that they can be
+	// replayed to clients that connect to the console, including while the server is
+	// offline.
+	ConsoleHistoryLines int `default:"1000" yaml:"console_history_lines"`
+
+	// Defines the crash reports that are generated when a server process crashes.
+	CrashReports CrashReportConfiguration `yaml:"crash_reports"`
+
+	// Defines where the server definitions for this daemon are stored.
+	Store StoreConfiguration `yaml:"store"`
+
+	// Defines how server events are delivered to websocket connections and other
+	// subscribers.
+	Events EventsConfiguration `yaml:"events"`
+
 	Sftp *SftpConfiguration `yaml:"sftp"`
 }
 
+// Defines the configuration for the crash reports generated by the daemon.
+type CrashReportConfiguration struct {
+	// If set to false, no crash reports will be generated for servers.
+	Enabled bool `default:"true" yaml:"enabled"`
+
+	// The number of bytes to read from the end of the server log when generating a
+	// crash report.
+	LogBytes int `default:"32768" yaml:"log_bytes"`
+
+	// The maximum number of console lines to include in a crash report.
+	LogLines int `default:"100" yaml:"log_lines"`
+
+	// The number of crash reports to keep for each server. Once this is exceeded the
+	// oldest reports are removed.
+	MaxReports int `default:"10" yaml:"max_reports"`
+}
+
+// Defines the storage used for server definitions.
+type StoreConfiguration struct {
+	// The store used to persist server definitions. The "yaml" store keeps each server in
+	// its own YAML file, while the "kv" store keeps all of the servers in a single file
+	// which is much faster to load on nodes with a large number of servers.
+	Driver string `default:"yaml" yaml:"driver"`
+
+	// The directory that server definitions are stored in. Relative paths are resolved
+	// from the working directory of the daemon.
+	Root string `default:"data/servers" yaml:"root"`
+}
+
+// Defines the delivery of server events to subscribers.
+type EventsConfiguration struct {
+	// The number of events that can be queued for each subscriber before it is treated
+	// as being too slow to keep up.
+	BufferSize int `default:"1024" yaml:"buffer_size"`
+
+	// What happens to a subscriber that is not keeping up once its queue is full. Using
+	// "drop" discards new events for the subscriber until it has caught up, while using
+	// "disconnect" removes the subscriber, closing the websocket connection if that is
+	// what it belongs to.
+	SlowConsumer string `default:"drop" yaml:"slow_consumer"`
+}
+
 // Defines the configuration of the internal SFTP server.
 type SftpConfiguration struct {
 	// If set to false, the internal SFTP server will not be booted and you will need
@@ -190,7 +325,10 @@ type ApiConfiguration struct {
 }
 
 // Reads the configuration from the provided file and returns the configuration
-// object that can then be used.
+// object that can then be used. If the file contains unknown settings or invalid
+// values a ValidationError is returned that describes every problem found, along
+// with the configuration as it was read so that the caller can decide whether the
+// problems should be fatal.
 func ReadConfiguration(path string) (*Configuration, error) {
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -213,14 +351,40 @@ func ReadConfiguration(path string) (*Configuration, error) {
 		return nil, err
 	}
 
+	var raw interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+
+	problems := unknownSettings("", raw, reflect.TypeOf(c))
+	problems = append(problems, c.validate()...)
+
+	// A null "sftp" block in the file replaces the default configuration with nothing. This
+	// is reported as a problem above, but the defaults are put back so that a daemon booting
+	// with the problem does not have to check for it everywhere the block is read.
+	if c.System.Sftp == nil {
+		c.System.Sftp = new(SftpConfiguration)
+		if err := defaults.Set(c.System.Sftp); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(problems) > 0 {
+		return c, &ValidationError{Problems: problems}
+	}
+
 	return c, nil
 }
 
 var _config *Configuration
+var _mutex sync.RWMutex
 var _debugViaFlag bool
 
 // Set the global configuration instance.
 func Set(c *Configuration) {
+	_mutex.Lock()
+	defer _mutex.Unlock()
+
 	_config = c
 }
 
@@ -228,8 +392,12 @@ func SetDebugViaFlag(d bool) {
 	_debugViaFlag = d
 }
 
-// Get the global configuration instance.
+// Get the global configuration instance. The returned configuration should not be
+// modified, a new instance is swapped in when the configuration is reloaded.
 func Get() *Configuration {
+	_mutex.RLock()
+	defer _mutex.RUnlock()
+
 	return _config
 }
 
@@ -345,33 +513,27 @@ func (c *Configuration) EnsureFilePermissions() error {
 	return nil
 }
 
-// Writes the configuration to the disk as a blocking operation by obtaining an exclusive
-// lock on the file. This prevents something else from writing at the exact same time and
-// leading to bad data conditions.
-func (c *Configuration) WriteToDisk() error {
-	f, err := os.OpenFile("config.yml", os.O_WRONLY, os.ModeExclusive)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// Coalesces writes of the configuration file to the disk.
+var _writer atomicfile.Writer
 
-	ccopy := *c
-	// If debugging is set with the flag, don't save that to the configuration file, otherwise
-	// you'll always end up in debug mode.
-	if _debugViaFlag {
-		ccopy.Debug = false
-	}
+// Writes the configuration to the disk. The file is replaced atomically so that a crash
+// while writing never leaves a corrupt configuration behind, and writes that happen while
+// another is in progress are combined into a single write.
+func (c *Configuration) WriteToDisk() error {
+	_, err := _writer.Write("config.yml", 0600, func() ([]byte, error) {
+		ccopy := *c
+		// If debugging is set with the flag, don't save that to the configuration file, otherwise
+		// you'll always end up in debug mode.
+		if _debugViaFlag {
+			ccopy.Debug = false
+		}
 
-	b, err := yaml.Marshal(&ccopy)
-	if err != nil {
-		return err
-	}
+		b, err := yaml.Marshal(&ccopy)
 
-	if _, err := f.Write(b); err != nil {
-		return err
-	}
+		return b, errors.WithStack(err)
+	})
 
-	return nil
+	return err
 }
 
 // Gets the system release name.
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"reflect"
+)
+
+// Returns the names of the settings that differ between the two configurations but are
+// only read when the daemon boots, meaning a change to them has no effect until the daemon
+// is restarted.
+func (c *Configuration) restartRequired(n *Configuration) []string {
+	var changed []string
+
+	check := func(name string, a interface{}, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+
+	check("debug", c.Debug, n.Debug)
+	check("api.host", c.Api.Host, n.Api.Host)
+	check("api.port", c.Api.Port, n.Api.Port)
+	check("api.ssl", c.Api.Ssl, n.Api.Ssl)
+	check("system.data", c.System.Data, n.System.Data)
+	check("system.username", c.System.Username, n.System.Username)
+	check("system.store", c.System.Store, n.System.Store)
+	check("system.sftp.use_internal", c.System.Sftp.UseInternalSystem, n.System.Sftp.UseInternalSystem)
+	check("system.sftp.bind_address", c.System.Sftp.Address, n.System.Sftp.Address)
+	check("system.sftp.bind_port", c.System.Sftp.Port, n.System.Sftp.Port)
+	check("system.sftp.read_only", c.System.Sftp.ReadOnly, n.System.Sftp.ReadOnly)
+	check("docker.network", c.Docker.Network, n.Docker.Network)
+	check("docker.socket", c.Docker.Socket, n.Docker.Socket)
+
+	return changed
+}
+
+// Reads and validates the configuration file at the given path again, and swaps it in as
+// the global configuration. Anything that reads the configuration through Get() will see
+// the new values from this point on.
+//
+// Settings that are only read when the daemon boots keep their current values so that the
+// global configuration continues to reflect what the daemon is actually using. The names
+// of any of those settings that were changed in the file are returned, they will only take
+// effect once the daemon is restarted.
+//
+// Unlike when the daemon boots, a configuration that fails validation is never swapped in.
+// The current configuration is kept and the ValidationError is returned so that every
+// problem with the file can be reported.
+func Reload(path string) ([]string, error) {
+	n, err := ReadConfiguration(path)
+	if err != nil {
+		return nil, err
+	}
+
+	_mutex.Lock()
+	defer _mutex.Unlock()
+
+	c := _config
+	if _debugViaFlag {
+		n.Debug = true
+	}
+
+	restart := c.restartRequired(n)
+
+	n.Debug = c.Debug
+	n.Api.Host = c.Api.Host
+	n.Api.Port = c.Api.Port
+	n.Api.Ssl = c.Api.Ssl
+	n.System.Data = c.System.Data
+	n.System.Username = c.System.Username
+	n.System.Store = c.System.Store
+	// The system user is looked up when the daemon boots and the IDs for it are not
+	// necessarily present in the configuration file.
+	n.System.User = c.System.User
+	n.System.Sftp.UseInternalSystem = c.System.Sftp.UseInternalSystem
+	n.System.Sftp.Address = c.System.Sftp.Address
+	n.System.Sftp.Port = c.System.Sftp.Port
+	n.System.Sftp.ReadOnly = c.System.Sftp.ReadOnly
+	n.Docker.Network = c.Docker.Network
+	n.Docker.Socket = c.Docker.Socket
+
+	_config = n
+
+	return restart, nil
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfiguration = `
+token: test-token
+remote: https://panel.example.com
+system:
+  data: /var/lib/pterodactyl/volumes
+  username: pterodactyl
+  sftp:
+    bind_port: 2022
+throttles:
+  kill_at_count: %s
+`
+
+// Returns a configuration that is otherwise valid but has an empty "sftp" block, which the
+// YAML library decodes as a nil pointer.
+func nullSftpConfiguration() string {
+	return strings.Replace(fmt.Sprintf(testConfiguration, "5"), "    bind_port: 2022\n", "", 1)
+}
+
+// Writes a configuration file to a temporary directory and returns the path to it, along
+// with a function that removes the directory.
+func writeTestConfiguration(t *testing.T, contents string) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "wings-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := filepath.Join(dir, "config.yml")
+	if err := ioutil.WriteFile(p, []byte(contents), 0644); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return p, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// Reads a valid configuration file and sets it as the global configuration for the
+// duration of a test. The returned function restores the previous configuration.
+func useTestConfiguration(t *testing.T) func() {
+	t.Helper()
+
+	p, remove := writeTestConfiguration(t, fmt.Sprintf(testConfiguration, "5"))
+	defer remove()
+
+	c, err := ReadConfiguration(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := Get()
+	Set(c)
+
+	return func() {
+		Set(previous)
+	}
+}
+
+func TestReloadSwapsInValidConfiguration(t *testing.T) {
+	defer useTestConfiguration(t)()
+
+	p, remove := writeTestConfiguration(t, fmt.Sprintf(testConfiguration, "10"))
+	defer remove()
+
+	restart, err := Reload(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restart) != 0 {
+		t.Errorf("expected no settings to require a restart, got %v", restart)
+	}
+
+	if Get().Throttles.KillAtCount != 10 {
+		t.Errorf("expected the reloaded configuration to be used, got a kill count of %d", Get().Throttles.KillAtCount)
+	}
+}
+
+func TestReloadRejectsInvalidConfiguration(t *testing.T) {
+	defer useTestConfiguration(t)()
+
+	previous := Get()
+
+	p, remove := writeTestConfiguration(t, fmt.Sprintf(testConfiguration, "0"))
+	defer remove()
+
+	restart, err := Reload(p)
+	if !IsValidationError(err) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	if restart != nil {
+		t.Errorf("expected no settings to be returned, got %v", restart)
+	}
+
+	if !hasProblem(err, "throttles.kill_at_count") {
+		t.Errorf("expected the invalid kill count to be reported, got %v", err)
+	}
+
+	if Get() != previous {
+		t.Error("expected the current configuration to be kept")
+	}
+}
+
+func TestReloadRejectsNullSftpConfiguration(t *testing.T) {
+	defer useTestConfiguration(t)()
+
+	previous := Get()
+
+	p, remove := writeTestConfiguration(t, nullSftpConfiguration())
+	defer remove()
+
+	if _, err := Reload(p); !hasProblem(err, "system.sftp") {
+		t.Fatalf("expected the missing sftp configuration to be reported, got %v", err)
+	}
+
+	if Get() != previous {
+		t.Error("expected the current configuration to be kept")
+	}
+}
+
+func TestReadConfigurationWithNullSftpConfiguration(t *testing.T) {
+	p, remove := writeTestConfiguration(t, nullSftpConfiguration())
+	defer remove()
+
+	c, err := ReadConfiguration(p)
+	if !hasProblem(err, "system.sftp") {
+		t.Fatalf("expected the missing sftp configuration to be reported, got %v", err)
+	}
+
+	if c.System.Sftp == nil || c.System.Sftp.Port != 2022 {
+		t.Errorf("expected the default sftp configuration to be used, got %+v", c.System.Sftp)
+	}
+}
+
+// Determines if the error is a ValidationError that includes a problem for the given path.
+func hasProblem(err error, path string) bool {
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		return false
+	}
+
+	for _, p := range verr.Problems {
+		if p.Path == path {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,309 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A single problem found with a configuration file, along with the YAML path of the
+// setting that caused it.
+type ValidationProblem struct {
+	Path    string
+	Message string
+}
+
+func (p ValidationProblem) String() string {
+	return p.Path + ": " + p.Message
+}
+
+// Returned when a configuration file contains settings that are unknown or that have
+// invalid values. Every problem found is included, not just the first one.
+type ValidationError struct {
+	Problems []ValidationProblem
+}
+
+func (e *ValidationError) Error() string {
+	s := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		s[i] = p.String()
+	}
+
+	return fmt.Sprintf("configuration is invalid: %s", strings.Join(s, "; "))
+}
+
+// Determines if an error was returned because the configuration failed validation.
+func IsValidationError(err error) bool {
+	_, ok := err.(*ValidationError)
+
+	return ok
+}
+
+type validator struct {
+	problems []ValidationProblem
+}
+
+func (v *validator) add(path string, format string, a ...interface{}) {
+	v.problems = append(v.problems, ValidationProblem{Path: path, Message: fmt.Sprintf(format, a...)})
+}
+
+func (v *validator) port(path string, port int) {
+	if port < 1 || port > 65535 {
+		v.add(path, "must be between 1 and 65535")
+	}
+}
+
+func (v *validator) positive(path string, n int) {
+	if n < 1 {
+		v.add(path, "must be greater than 0")
+	}
+}
+
+func (v *validator) notNegative(path string, n int) {
+	if n < 0 {
+		v.add(path, "must not be negative")
+	}
+}
+
+func (v *validator) file(path string, name string) {
+	if name == "" {
+		v.add(path, "must be set")
+	} else if _, err := os.Stat(name); err != nil {
+		v.add(path, "file cannot be read: %s", err)
+	}
+}
+
+// Checks that a subnet and its gateway are valid, and returns the parsed subnet if so.
+func (v *validator) subnet(path string, subnet string, gateway string) *net.IPNet {
+	_, n, err := net.ParseCIDR(subnet)
+	if err != nil {
+		v.add(path+".subnet", "must be a subnet in CIDR notation")
+		return nil
+	}
+
+	if ip := net.ParseIP(gateway); ip == nil {
+		v.add(path+".gateway", "must be an IP address")
+	} else if !n.Contains(ip) {
+		v.add(path+".gateway", "must be an address within %s", n)
+	}
+
+	return n
+}
+
+// Checks the values of the configuration and returns every problem that was found.
+func (c *Configuration) validate() []ValidationProblem {
+	v := new(validator)
+
+	if c.AuthenticationToken == "" {
+		v.add("token", "must be set")
+	}
+
+	if u, err := url.Parse(c.PanelLocation); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.add("remote", "must be an http or https URL")
+	}
+
+	v.notNegative("disk_check_timeout", c.DiskCheckTimeout)
+
+	if c.Api.Host == "" {
+		v.add("api.host", "must be set")
+	}
+	v.port("api.port", c.Api.Port)
+	if c.Api.Ssl.Enabled {
+		v.file("api.ssl.cert", c.Api.Ssl.CertificateFile)
+		v.file("api.ssl.key", c.Api.Ssl.KeyFile)
+	}
+	v.positive("api.upload_limit", c.Api.UploadLimit)
+
+	if !filepath.IsAbs(c.System.Data) {
+		v.add("system.data", "must be an absolute path")
+	}
+	if c.System.Username == "" {
+		v.add("system.username", "must be set")
+	}
+	v.notNegative("system.stop_timeout", c.System.StopTimeout)
+	v.notNegative("system.power_lock_timeout", c.System.PowerLockTimeout)
+	v.notNegative("system.shutdown_timeout", c.System.ShutdownTimeout)
+	v.positive("system.console_history_lines", c.System.ConsoleHistoryLines)
+	if c.System.CrashReports.Enabled {
+		v.notNegative("system.crash_reports.log_bytes", c.System.CrashReports.LogBytes)
+		v.notNegative("system.crash_reports.log_lines", c.System.CrashReports.LogLines)
+		v.positive("system.crash_reports.max_reports", c.System.CrashReports.MaxReports)
+	}
+
+	if c.System.Store.Driver != "yaml" && c.System.Store.Driver != "kv" {
+		v.add("system.store.driver", "must be one of yaml or kv")
+	}
+	if c.System.Store.Root == "" {
+		v.add("system.store.root", "must be set")
+	}
+
+	v.positive("system.events.buffer_size", c.System.Events.BufferSize)
+	if c.System.Events.SlowConsumer != "drop" && c.System.Events.SlowConsumer != "disconnect" {
+		v.add("system.events.slow_consumer", "must be one of drop or disconnect")
+	}
+
+	if c.System.Sftp == nil {
+		v.add("system.sftp", "must be set")
+	} else if c.System.Sftp.UseInternalSystem {
+		if net.ParseIP(c.System.Sftp.Address) == nil {
+			v.add("system.sftp.bind_address", "must be an IP address")
+		}
+		v.port("system.sftp.bind_port", c.System.Sftp.Port)
+	}
+
+	if c.Docker.Socket == "" {
+		v.add("docker.socket", "must be set")
+	}
+	if c.Docker.Network.Name == "" {
+		v.add("docker.network.name", "must be set")
+	}
+	if net.ParseIP(c.Docker.Network.Interface) == nil {
+		v.add("docker.network.interface", "must be an IP address")
+	}
+
+	v4 := v.subnet("docker.network.interfaces.v4", c.Docker.Network.Interfaces.V4.Subnet, c.Docker.Network.Interfaces.V4.Gateway)
+	v6 := v.subnet("docker.network.interfaces.v6", c.Docker.Network.Interfaces.V6.Subnet, c.Docker.Network.Interfaces.V6.Gateway)
+	c.checkHostOverlap(v, "docker.network.interfaces.v4.subnet", v4)
+	c.checkHostOverlap(v, "docker.network.interfaces.v6.subnet", v6)
+
+	v.positive("throttles.kill_at_count", c.Throttles.KillAtCount)
+	v.positive("throttles.decay", c.Throttles.DecaySeconds)
+	v.positive("throttles.bytes", c.Throttles.BytesPerInterval)
+	// This is allowed to be zero since the default for it was previously not being applied,
+	// meaning existing configuration files will have a zero value written to them.
+	v.notNegative("throttles.check_interval", c.Throttles.CheckInterval)
+
+	v.positive("remote_query.timeout", c.RemoteQuery.Timeout)
+	v.notNegative("remote_query.retries", c.RemoteQuery.Retries)
+	v.notNegative("remote_query.retry_delay", c.RemoteQuery.RetryDelay)
+	if c.RemoteQuery.MaxRetryDelay < c.RemoteQuery.RetryDelay {
+		v.add("remote_query.max_retry_delay", "must not be less than remote_query.retry_delay")
+	}
+	v.positive("remote_query.failure_threshold", c.RemoteQuery.FailureThreshold)
+	v.notNegative("remote_query.cooldown", c.RemoteQuery.Cooldown)
+
+	v.notNegative("tokens.clock_skew", c.Tokens.ClockSkew)
+	v.positive("tokens.revocation_ttl", c.Tokens.RevocationTTL)
+
+	ids := make(map[string]bool)
+	for i, k := range c.Tokens.SigningKeys {
+		path := fmt.Sprintf("tokens.signing_keys.%d", i)
+		if k.Id == "" {
+			v.add(path+".id", "must be set")
+		} else if ids[k.Id] {
+			v.add(path+".id", "must be unique, \"%s\" is already used", k.Id)
+		}
+		ids[k.Id] = true
+
+		if k.Secret == "" {
+			v.add(path+".secret", "must be set")
+		}
+	}
+
+	return v.problems
+}
+
+// Checks that the Docker network subnet does not overlap with a network that one of the
+// host's interfaces is already on. The interface for the Docker network itself is ignored
+// since it will exist on the host once the daemon has created the network.
+func (c *Configuration) checkHostOverlap(v *validator, path string, subnet *net.IPNet) {
+	if subnet == nil {
+		return
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return
+	}
+
+	gateways := []net.IP{
+		net.ParseIP(c.Docker.Network.Interface),
+		net.ParseIP(c.Docker.Network.Interfaces.V4.Gateway),
+		net.ParseIP(c.Docker.Network.Interfaces.V6.Gateway),
+	}
+
+	for _, addr := range addrs {
+		ip, n, err := net.ParseCIDR(addr.String())
+		if err != nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+
+		var isGateway bool
+		for _, g := range gateways {
+			if g != nil && g.Equal(ip) {
+				isGateway = true
+				break
+			}
+		}
+
+		if !isGateway && (n.Contains(subnet.IP) || subnet.Contains(n.IP)) {
+			v.add(path, "overlaps with %s used by a network interface on this system", n)
+		}
+	}
+}
+
+// Compares the keys in the raw YAML document aganist the fields of the given type and
+// returns a problem for each key that does not match a setting, which is usually caused
+// by a typo in the configuration file.
+func unknownSettings(prefix string, raw interface{}, t reflect.Type) []ValidationProblem {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if l, ok := raw.([]interface{}); ok && t.Kind() == reflect.Slice {
+		var problems []ValidationProblem
+		for i, v := range l {
+			problems = append(problems, unknownSettings(fmt.Sprintf("%s%d.", prefix, i), v, t.Elem())...)
+		}
+
+		return problems
+	}
+
+	m, ok := raw.(map[interface{}]interface{})
+	if !ok || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		// The YAML library uses the lowercased field name as the key when one is not
+		// defined in the tag.
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		} else if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		fields[name] = f.Type
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, fmt.Sprint(k))
+	}
+	sort.Strings(keys)
+
+	var problems []ValidationProblem
+	for _, k := range keys {
+		ft, ok := fields[k]
+		if !ok {
+			problems = append(problems, ValidationProblem{Path: prefix + k, Message: "is not a known setting"})
+			continue
+		}
+
+		problems = append(problems, unknownSettings(prefix+k+".", m[k], ft)...)
+	}
+
+	return problems
+}
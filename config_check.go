@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pterodactyl/wings/config"
+	"os"
+)
+
+// Handles the "config" subcommands, which operate on a configuration file without starting
+// the daemon.
+func runConfigCommand(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: wings config check [-config path]")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	path := fs.String("config", "config.yml", "set the location for the configuration file")
+	fs.Parse(args[1:])
+
+	if _, err := config.ReadConfiguration(*path); err != nil {
+		printConfigurationError(*path, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s is valid\n", *path)
+}
+
+// Prints an error returned when reading a configuration file. If the configuration failed
+// validation each of the problems is printed on its own line.
+func printConfigurationError(path string, err error) {
+	verr, ok := err.(*config.ValidationError)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "failed to read configuration from %s: %s\n", path, err)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%s is invalid, found %d problem(s):\n", path, len(verr.Problems))
+	for _, p := range verr.Problems {
+		fmt.Fprintf(os.Stderr, "  %s\n", p)
+	}
+}
@@ -0,0 +1,625 @@
+// Package dockertest provides an in-memory implementation of the Docker engine API calls
+// made by the daemon. It can be injected using server.SetDockerClient so that the Docker
+// environment and installation process can be exercised on a machine without a Docker
+// daemon running.
+//
+// The engine does not run anything itself. Output, exits and stats for a container are
+// driven by calling the Output, Exit and Stats functions, or by registering the OnStart
+// and OnCommand hooks to script how a container behaves.
+package dockertest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/pterodactyl/wings/server"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ensure that the engine always implements all of the calls made by the daemon.
+var _ server.DockerClient = (*Engine)(nil)
+
+// Defines a container that has been created in the engine.
+type Container struct {
+	ID         string
+	Name       string
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	Created    time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Running    bool
+	ExitCode   int
+	OOMKilled  bool
+	LogPath    string
+
+	// All of the output lines written by the container since it was last started.
+	Output []string
+
+	// All of the commands written to the stdin of the container by attached clients.
+	Commands []string
+
+	attachments []*stream
+	conns       []net.Conn
+	followers   []*stream
+	stats       []*stream
+	waiters     []chan container.ContainerWaitOKBody
+}
+
+// An in-memory Docker engine.
+type Engine struct {
+	// Called after a container has been started. This is run in a separate goroutine so
+	// it is safe to call back into the engine, for example to write output or exit.
+	OnStart func(e *Engine, id string)
+
+	// Called each time a command is written to the stdin of an attached container.
+	OnCommand func(e *Engine, id string, command string)
+
+	mu         sync.Mutex
+	dir        string
+	containers map[string]*Container
+	images     map[string]bool
+	networks   map[string]types.NetworkResource
+}
+
+// Creates a new engine. Container log files are written to a temporary directory which
+// is removed when the engine is closed.
+func New() (*Engine, error) {
+	d, err := ioutil.TempDir("", "dockertest")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Engine{
+		dir:        d,
+		containers: make(map[string]*Container),
+		images:     make(map[string]bool),
+		networks:   make(map[string]types.NetworkResource),
+	}, nil
+}
+
+// Removes the temporary directory used by the engine.
+func (e *Engine) Close() error {
+	return os.RemoveAll(e.dir)
+}
+
+// Adds an image to the engine so that containers can be created from it without being
+// pulled first.
+func (e *Engine) AddImage(ref string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.images[ref] = true
+}
+
+// Returns a copy of the container with the given ID or name.
+func (e *Engine) Container(id string) (Container, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return Container{}, false
+	}
+
+	return *c, true
+}
+
+// Writes a line of output from the container. This is sent to any attached clients and
+// log followers, and is written to the container log file.
+func (e *Engine) Output(id string, line string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return notFound("container", id)
+	}
+
+	c.Output = append(c.Output, line)
+
+	for _, s := range c.attachments {
+		s.Write([]byte(line + "\n"))
+	}
+
+	for _, s := range c.followers {
+		s.Write([]byte(line + "\n"))
+	}
+
+	b, _ := json.Marshal(map[string]string{
+		"log":    line + "\n",
+		"stream": "stdout",
+		"time":   time.Now().UTC().Format(time.RFC3339Nano),
+	})
+
+	f, err := os.OpenFile(c.LogPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+
+	return err
+}
+
+// Sends a stats sample to any clients streaming stats for the container.
+func (e *Engine) Stats(id string, v *types.StatsJSON) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return notFound("container", id)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range c.stats {
+		s.Write(b)
+	}
+
+	return nil
+}
+
+// Stops a running container with the given exit code. All of the streams for the
+// container are closed and anything waiting on the container is notified.
+func (e *Engine) Exit(id string, code int, oomKilled bool) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return notFound("container", id)
+	}
+
+	e.exit(c, code, oomKilled)
+
+	return nil
+}
+
+// Returns the container matching the given ID or name. The engine lock must be held
+// when calling this function.
+func (e *Engine) find(id string) *Container {
+	id = strings.TrimPrefix(id, "/")
+
+	if c, ok := e.containers[id]; ok {
+		return c
+	}
+
+	for _, c := range e.containers {
+		if c.Name == id {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// Marks the container as stopped and closes all of its streams. The engine lock must be
+// held when calling this function.
+func (e *Engine) exit(c *Container, code int, oomKilled bool) {
+	if !c.Running {
+		return
+	}
+
+	c.Running = false
+	c.ExitCode = code
+	c.OOMKilled = oomKilled
+	c.FinishedAt = time.Now()
+
+	for _, s := range c.attachments {
+		s.Close()
+	}
+
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+
+	for _, s := range c.followers {
+		s.Close()
+	}
+
+	for _, s := range c.stats {
+		s.Close()
+	}
+
+	for _, w := range c.waiters {
+		w <- container.ContainerWaitOKBody{StatusCode: int64(code)}
+	}
+
+	c.attachments, c.conns, c.followers, c.stats, c.waiters = nil, nil, nil, nil, nil
+}
+
+func (e *Engine) ContainerAttach(ctx context.Context, id string, options types.ContainerAttachOptions) (types.HijackedResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return types.HijackedResponse{}, notFound("container", id)
+	}
+
+	if !c.Running {
+		return types.HijackedResponse{}, fmt.Errorf("container %s is not running", id)
+	}
+
+	out := newStream()
+	client, srv := net.Pipe()
+
+	c.attachments = append(c.attachments, out)
+	c.conns = append(c.conns, srv)
+
+	// Read anything written by the client into the stdin of the container, recording
+	// each line as a command.
+	go func(cid string) {
+		s := bufio.NewScanner(srv)
+		for s.Scan() {
+			e.mu.Lock()
+			if c := e.find(cid); c != nil {
+				c.Commands = append(c.Commands, s.Text())
+			}
+			e.mu.Unlock()
+
+			if e.OnCommand != nil {
+				e.OnCommand(e, cid, s.Text())
+			}
+		}
+	}(c.ID)
+
+	return types.HijackedResponse{Conn: client, Reader: bufio.NewReader(out)}, nil
+}
+
+func (e *Engine) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, name string) (container.ContainerCreateCreatedBody, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if name != "" && e.find(name) != nil {
+		return container.ContainerCreateCreatedBody{}, fmt.Errorf("conflict: the container name \"/%s\" is already in use", name)
+	}
+
+	if !e.images[config.Image] {
+		return container.ContainerCreateCreatedBody{}, notFound("image", config.Image)
+	}
+
+	id := randomId()
+	e.containers[id] = &Container{
+		ID:         id,
+		Name:       name,
+		Config:     config,
+		HostConfig: hostConfig,
+		Created:    time.Now(),
+		LogPath:    filepath.Join(e.dir, id+"-json.log"),
+	}
+
+	return container.ContainerCreateCreatedBody{ID: id}, nil
+}
+
+func (e *Engine) ContainerInspect(ctx context.Context, id string) (types.ContainerJSON, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return types.ContainerJSON{}, notFound("container", id)
+	}
+
+	status := "created"
+	if c.Running {
+		status = "running"
+	} else if !c.FinishedAt.IsZero() {
+		status = "exited"
+	}
+
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			ID:      c.ID,
+			Name:    "/" + c.Name,
+			Created: c.Created.Format(time.RFC3339Nano),
+			Image:   c.Config.Image,
+			LogPath: c.LogPath,
+			State: &types.ContainerState{
+				Status:     status,
+				Running:    c.Running,
+				OOMKilled:  c.OOMKilled,
+				ExitCode:   c.ExitCode,
+				StartedAt:  formatTime(c.StartedAt),
+				FinishedAt: formatTime(c.FinishedAt),
+			},
+			HostConfig: c.HostConfig,
+		},
+		Config: c.Config,
+	}, nil
+}
+
+func (e *Engine) ContainerKill(ctx context.Context, id, signal string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return notFound("container", id)
+	}
+
+	if !c.Running {
+		return fmt.Errorf("container %s is not running", id)
+	}
+
+	code := 143
+	if strings.Contains(strings.ToLower(signal), "kill") {
+		code = 137
+	}
+
+	e.exit(c, code, false)
+
+	return nil
+}
+
+func (e *Engine) ContainerLogs(ctx context.Context, id string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return nil, notFound("container", id)
+	}
+
+	var history bytes.Buffer
+	if options.Since == "" {
+		for _, l := range c.Output {
+			history.WriteString(l + "\n")
+		}
+	}
+
+	if !options.Follow {
+		return ioutil.NopCloser(&history), nil
+	}
+
+	s := newStream()
+	s.Write(history.Bytes())
+
+	if c.Running {
+		c.followers = append(c.followers, s)
+	} else {
+		s.Close()
+	}
+
+	return s, nil
+}
+
+func (e *Engine) ContainerRemove(ctx context.Context, id string, options types.ContainerRemoveOptions) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return notFound("container", id)
+	}
+
+	if c.Running {
+		if !options.Force {
+			return fmt.Errorf("conflict: you cannot remove a running container %s", c.ID)
+		}
+
+		e.exit(c, 137, false)
+	}
+
+	delete(e.containers, c.ID)
+	os.Remove(c.LogPath)
+
+	return nil
+}
+
+func (e *Engine) ContainerStart(ctx context.Context, id string, options types.ContainerStartOptions) error {
+	e.mu.Lock()
+
+	c := e.find(id)
+	if c == nil {
+		e.mu.Unlock()
+
+		return notFound("container", id)
+	}
+
+	if c.Running {
+		e.mu.Unlock()
+
+		return nil
+	}
+
+	c.Running = true
+	c.StartedAt = time.Now()
+	c.FinishedAt = time.Time{}
+	c.ExitCode = 0
+	c.OOMKilled = false
+	c.Output = nil
+	c.Commands = nil
+	e.mu.Unlock()
+
+	if e.OnStart != nil {
+		go e.OnStart(e, c.ID)
+	}
+
+	return nil
+}
+
+func (e *Engine) ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return types.ContainerStats{}, notFound("container", id)
+	}
+
+	s := newStream()
+	if c.Running && stream {
+		c.stats = append(c.stats, s)
+	} else {
+		s.Close()
+	}
+
+	return types.ContainerStats{Body: s, OSType: "linux"}, nil
+}
+
+func (e *Engine) ContainerStop(ctx context.Context, id string, timeout *time.Duration) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return notFound("container", id)
+	}
+
+	e.exit(c, 0, false)
+
+	return nil
+}
+
+func (e *Engine) ContainerUpdate(ctx context.Context, id string, updateConfig container.UpdateConfig) (container.ContainerUpdateOKBody, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		return container.ContainerUpdateOKBody{}, notFound("container", id)
+	}
+
+	if c.HostConfig == nil {
+		c.HostConfig = &container.HostConfig{}
+	}
+
+	c.HostConfig.Resources = updateConfig.Resources
+
+	return container.ContainerUpdateOKBody{}, nil
+}
+
+func (e *Engine) ContainerWait(ctx context.Context, id string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	result := make(chan container.ContainerWaitOKBody, 1)
+	errs := make(chan error, 1)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	c := e.find(id)
+	if c == nil {
+		errs <- notFound("container", id)
+
+		return result, errs
+	}
+
+	if !c.Running {
+		result <- container.ContainerWaitOKBody{StatusCode: int64(c.ExitCode)}
+
+		return result, errs
+	}
+
+	w := make(chan container.ContainerWaitOKBody, 1)
+	c.waiters = append(c.waiters, w)
+
+	go func() {
+		select {
+		case r := <-w:
+			result <- r
+		case <-ctx.Done():
+			errs <- ctx.Err()
+		}
+	}()
+
+	return result, errs
+}
+
+func (e *Engine) ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.images[ref] = true
+
+	return ioutil.NopCloser(strings.NewReader(`{"status":"Downloaded newer image for ` + ref + `"}` + "\n")), nil
+}
+
+func (e *Engine) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.networks[name]; ok {
+		return types.NetworkCreateResponse{}, fmt.Errorf("network with name %s already exists", name)
+	}
+
+	id := randomId()
+	e.networks[name] = types.NetworkResource{
+		Name:     name,
+		ID:       id,
+		Driver:   options.Driver,
+		Internal: options.Internal,
+		Options:  options.Options,
+	}
+
+	return types.NetworkCreateResponse{ID: id}, nil
+}
+
+func (e *Engine) NetworkInspect(ctx context.Context, id string, options types.NetworkInspectOptions) (types.NetworkResource, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for name, n := range e.networks {
+		if name == id || n.ID == id {
+			return n, nil
+		}
+	}
+
+	return types.NetworkResource{}, notFound("network", id)
+}
+
+// Error returned when an object does not exist in the engine. This satisfies the checks
+// performed by client.IsErrNotFound.
+type notFoundError struct {
+	object string
+	id     string
+}
+
+func (e notFoundError) Error() string {
+	return fmt.Sprintf("Error: No such %s: %s", e.object, e.id)
+}
+
+func (e notFoundError) NotFound() bool {
+	return true
+}
+
+func notFound(object string, id string) error {
+	return notFoundError{object: object, id: id}
+}
+
+// Generates a random identifier in the same format that Docker uses.
+func randomId() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Formats a time in the same way Docker does for container states, returning the zero
+// time used by Docker if the time is not set.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "0001-01-01T00:00:00Z"
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,66 @@
+package dockertest
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// An in-memory stream that never blocks when being written to, and blocks when being
+// read from until data is available or the stream is closed. This is used for all of the
+// long running streams returned by the engine, such as attached output, followed logs,
+// and container stats.
+type stream struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    bytes.Buffer
+	closed bool
+}
+
+func newStream() *stream {
+	s := &stream{}
+	s.cond = sync.NewCond(&s.mu)
+
+	return s
+}
+
+// Writes data to the stream. Writing to a closed stream silently discards the data.
+func (s *stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.closed {
+		s.buf.Write(p)
+		s.cond.Broadcast()
+	}
+
+	return len(p), nil
+}
+
+// Reads data from the stream, blocking until data is available. Once the stream has
+// been closed and all of the remaining data read, io.EOF is returned.
+func (s *stream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.buf.Len() == 0 && !s.closed {
+		s.cond.Wait()
+	}
+
+	if s.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+
+	return s.buf.Read(p)
+}
+
+// Closes the stream, any data that has already been written can still be read.
+func (s *stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	s.cond.Broadcast()
+
+	return nil
+}
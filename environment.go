@@ -6,23 +6,24 @@ import (
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
 	"go.uber.org/zap"
 )
 
 // Configures the required network for the docker environment.
-func ConfigureDockerEnvironment(c *config.DockerConfiguration) error {
+func ConfigureDockerEnvironment(ctx context.Context, c *config.DockerConfiguration) error {
 	// Ensure the required docker network exists on the system.
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := server.GetDockerClient()
 	if err != nil {
 		return err
 	}
 
-	resource, err := cli.NetworkInspect(context.Background(), c.Network.Name, types.NetworkInspectOptions{})
+	resource, err := cli.NetworkInspect(ctx, c.Network.Name, types.NetworkInspectOptions{})
 	if err != nil && client.IsErrNotFound(err) {
 		zap.S().Infow("creating missing pterodactyl0 interface, this could take a few seconds...")
-		return createDockerNetwork(cli, c)
+		return createDockerNetwork(ctx, cli, c)
 	} else if err != nil {
-		zap.S().Fatalw("failed to create required docker network for containers", zap.Error(err))
+		return err
 	}
 
 	switch resource.Driver {
@@ -42,9 +43,20 @@ func ConfigureDockerEnvironment(c *config.DockerConfiguration) error {
 	return nil
 }
 
+// Determines if any of the servers loaded by the daemon run in the Docker environment.
+func usesDockerEnvironment() bool {
+	for _, s := range server.GetServers().All() {
+		if s.Environment.Type() == "docker" {
+			return true
+		}
+	}
+
+	return false
+}
+
 // Creates a new network on the machine if one does not exist already.
-func createDockerNetwork(cli *client.Client, c *config.DockerConfiguration) error {
-	_, err := cli.NetworkCreate(context.Background(), c.Network.Name, types.NetworkCreate{
+func createDockerNetwork(ctx context.Context, cli server.DockerClient, c *config.DockerConfiguration) error {
+	_, err := cli.NetworkCreate(ctx, c.Network.Name, types.NetworkCreate{
 		Driver:     c.Network.Driver,
 		EnableIPv6: true,
 		Internal:   c.Network.IsInternal,
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/buger/jsonparser"
@@ -18,6 +19,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Retrieves a server out of the collection by UUID.
@@ -30,13 +33,95 @@ func (rt *Router) GetServer(uuid string) *server.Server {
 type Router struct {
 	upgrader websocket.Upgrader
 
-	// The authentication token defined in the config.yml file that allows
-	// a request to perform any action aganist the daemon.
-	token string
+	// Context used for long running operations started by requests, such as server
+	// installations. This is cancelled if those operations do not finish in time when the
+	// daemon is shutting down.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Tracks the background operations started by requests, and wether or not the daemon
+	// has stopped accepting new ones because it is waiting for them to complete.
+	tasks       sync.WaitGroup
+	tasksMutex  sync.Mutex
+	tasksClosed bool
+
+	// The websocket connections that are currently open, and wether or not the daemon has
+	// stopped accepting new connections because it is shutting down.
+	sockets      map[*WebsocketHandler]struct{}
+	socketsMutex sync.Mutex
+	closing      bool
 }
 
-func (rt *Router) AuthenticateRequest(h httprouter.Handle) httprouter.Handle {
-	return rt.AuthenticateToken(rt.AuthenticateServer(h))
+// Creates a new router that uses the given upgrader for websocket connections.
+func NewRouter(upgrader websocket.Upgrader) *Router {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Router{
+		upgrader: upgrader,
+		ctx:      ctx,
+		cancel:   cancel,
+		sockets:  make(map[*WebsocketHandler]struct{}),
+	}
+}
+
+// Middleware that authenticates every API request and enforces the permission declared for
+// the route. Requests can use either the global token for the daemon, which is granted every
+// permission, or a token issued by the Panel for a server. Server tokens are only accepted
+// on the routes for the server they were issued for and must grant the permission, and are
+// never accepted on routes that require an administrative permission.
+//
+// An empty permission means the route performs its own permission check once it knows what
+// is being requested, using the token returned by TokenFromRequest.
+func (rt *Router) AuthenticateRequest(permission Permission, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		token, ok := bearerToken(w, r)
+		if !ok {
+			return
+		}
+
+		if token != config.Get().AuthenticationToken {
+			payload, err := ParseToken([]byte(token))
+			if err != nil {
+				http.Error(w, "authorization failed", http.StatusForbidden)
+				return
+			}
+
+			u := ps.ByName("server")
+			if u == "" || payload.ServerUUID != u || permission.isAdmin() || (permission != "" && !payload.HasPermission(permission)) {
+				http.Error(w, "token does not have permission to perform this action", http.StatusForbidden)
+				return
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), tokenPayloadKey, payload))
+		}
+
+		if ps.ByName("server") != "" && rt.GetServer(ps.ByName("server")) == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		h(rt.AttachAccessControlHeaders(w, r, ps))
+	}
+}
+
+type contextKey int
+
+const tokenPayloadKey contextKey = iota
+
+// Returns the server token used to authenticate a request, or nil if the request was made
+// using the global token for the daemon.
+func TokenFromRequest(r *http.Request) *WebsocketTokenPayload {
+	payload, _ := r.Context().Value(tokenPayloadKey).(*WebsocketTokenPayload)
+
+	return payload
+}
+
+// Determines if the token used for a request grants a permission. Requests made using the
+// global token for the daemon are granted every permission.
+func HasRequestPermission(r *http.Request, permission Permission) bool {
+	payload := TokenFromRequest(r)
+
+	return payload == nil || payload.HasPermission(permission)
 }
 
 // Middleware to protect server specific routes. This will ensure that the server exists and
@@ -60,35 +145,19 @@ func (rt *Router) AttachAccessControlHeaders(w http.ResponseWriter, r *http.Requ
 	return w, r, ps
 }
 
-// Authenticates the request token aganist the given permission string, ensuring that
-// if it is a server permission, the token has control over that server. If it is a global
-// token, this will ensure that the request is using a properly signed global token.
-func (rt *Router) AuthenticateToken(h httprouter.Handle) httprouter.Handle {
-	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		// Adds support for using this middleware on the websocket routes for servers. Those
-		// routes don't support Authorization headers, per the spec, so we abuse the socket
-		// protocol header and use that to pass the authorization token along to Wings without
-		// exposing the token in the URL directly. Neat. 📸
-		auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
-
-		if len(auth) != 2 || auth[0] != "Bearer" {
-			w.Header().Set("WWW-Authenticate", "Bearer")
-			http.Error(w, "authorization failed", http.StatusUnauthorized)
-			return
-		}
+// Returns the bearer token from the Authorization header of a request. If the header is
+// missing or invalid an error response is sent and false is returned.
+func bearerToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
 
-		// Try to match the request aganist the global token for the Daemon, regardless
-		// of the permission type. If nothing is matched we will fall through to the Panel
-		// API to try and validate permissions for a server.
-		if auth[1] == rt.token {
-			h(rt.AttachAccessControlHeaders(w, r, ps))
-			return
-		}
+	if len(auth) != 2 || auth[0] != "Bearer" {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "authorization failed", http.StatusUnauthorized)
 
-		// Happens because we don't have any of the server handling code here.
-		http.Error(w, "not implemented", http.StatusNotImplemented)
-		return
+		return "", false
 	}
+
+	return auth[1], true
 }
 
 // Returns the basic Wings index page without anything else.
@@ -112,6 +181,20 @@ func (rt *Router) routeServer(w http.ResponseWriter, _ *http.Request, ps httprou
 
 type PowerActionRequest struct {
 	Action string `json:"action"`
+
+	// If set to true and another power action is currently being processed for the
+	// server, this request will be queued until that action completes. Otherwise the
+	// request is rejected immediately.
+	Wait bool `json:"wait"`
+
+	// If set to a value greater than zero when stopping a server, the request will block
+	// until the server process has completely stopped, or until this many seconds have
+	// passed. This is ignored for all other power actions.
+	StopTimeout int `json:"stop_timeout"`
+
+	// Used alongside StopTimeout to forcibly terminate the server process if it has not
+	// stopped once the timeout is reached.
+	TerminateOnTimeout bool `json:"terminate_on_timeout"`
 }
 
 type CreateDirectoryRequest struct {
@@ -120,7 +203,7 @@ type CreateDirectoryRequest struct {
 }
 
 func (pr *PowerActionRequest) IsValid() bool {
-	return pr.Action == "start" || pr.Action == "stop" || pr.Action == "kill" || pr.Action == "restart"
+	return server.IsValidPowerAction(pr.Action)
 }
 
 // Handles a request to control the power state of a server. If the action being passed
@@ -131,6 +214,9 @@ func (pr *PowerActionRequest) IsValid() bool {
 // This is done because for the most part the Panel is using websockets to determine when
 // things are happening, so theres no reason to sit and wait for a request to finish. We'll
 // just see over the socket if something isn't working correctly.
+//
+// The exception to this is a stop action that includes a stop timeout, in which case the
+// request blocks until the server is offline and a HTTP/204 is returned.
 func (rt *Router) routeServerPower(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	s := rt.GetServer(ps.ByName("server"))
 	defer r.Body.Close()
@@ -154,54 +240,77 @@ func (rt *Router) routeServerPower(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 
+	if !HasRequestPermission(r, powerPermission(action.Action)) {
+		http.Error(w, "token does not have permission to perform this action", http.StatusForbidden)
+		return
+	}
+
 	// Because we route all of the actual bootup process to a seperate thread we need to
 	// check the suspension status here, otherwise the user will hit the endpoint and then
 	// just sit there wondering why it returns a success but nothing actually happens.
 	//
 	// We don't really care about any of the other actions at this point, they'll all result
 	// in the process being stopped, which should have happened anyways if the server is suspended.
-	if action.Action == "start" && s.Suspended {
+	if (action.Action == "start" || action.Action == "restart") && s.Suspended {
 		http.Error(w, "server is suspended", http.StatusBadRequest)
 		return
 	}
 
+	// Obtain the power lock for the server before responding so that a conflicting request
+	// can be rejected with a clear error. If the request asked to wait, this will block
+	// until any running power action finishes, or the lock times out.
+	if err := s.AcquirePowerLock(action.Wait); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	// If the caller would like to wait for the server to be fully stopped, handle the
+	// action in this thread and only respond once the process is offline.
+	if action.Action == server.PowerActionStop && action.StopTimeout > 0 {
+		defer s.ReleasePowerLock()
+
+		err := s.Environment.WaitForStop(r.Context(), time.Second*time.Duration(action.StopTimeout), action.TerminateOnTimeout)
+		if err != nil {
+			if errors.Cause(err) == context.DeadlineExceeded {
+				http.Error(w, "server did not stop within the allotted time", http.StatusGatewayTimeout)
+				return
+			}
+
+			zap.S().Errorw("failed to wait for server process to stop", zap.String("server", s.Uuid), zap.Error(err))
+
+			http.Error(w, "failed to stop server process", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
 	// Pass the actual heavy processing off to a seperate thread to handle so that
 	// we can immediately return a response from the server.
-	go func(a string, s *server.Server) {
-		switch a {
-		case "start":
-			if err := s.Environment.Start(); err != nil {
-				zap.S().Errorw(
-					"encountered unexpected error starting server process",
-					zap.Error(err),
-					zap.String("server", s.Uuid),
-					zap.String("action", "start"),
-				)
-			}
-			break
-		case "stop":
-			if err := s.Environment.Stop(); err != nil {
-				zap.S().Errorw(
-					"encountered unexpected error stopping server process",
-					zap.Error(err),
-					zap.String("server", s.Uuid),
-					zap.String("action", "stop"),
-				)
-			}
-			break
-		case "restart":
-			break
-		case "kill":
-			if err := s.Environment.Terminate(os.Kill); err != nil {
-				zap.S().Errorw(
-					"encountered unexpected error killing server process",
-					zap.Error(err),
-					zap.String("server", s.Uuid),
-					zap.String("action", "kill"),
-				)
-			}
+	//
+	// Power actions are not cancelled when the daemon is shutting down since doing so could
+	// leave the server process in an inconsistent state.
+	a := action.Action
+	ok := rt.runTask(func() {
+		defer s.ReleasePowerLock()
+
+		if err := s.HandlePowerAction(context.Background(), a); err != nil {
+			zap.S().Errorw(
+				"encountered unexpected error processing server power action",
+				zap.Error(err),
+				zap.String("server", s.Uuid),
+				zap.String("action", a),
+			)
 		}
-	}(action.Action, s)
+	})
+
+	if !ok {
+		s.ReleasePowerLock()
+
+		http.Error(w, errShuttingDown, http.StatusServiceUnavailable)
+		return
+	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -225,6 +334,44 @@ func (rt *Router) routeServerLogs(w http.ResponseWriter, r *http.Request, ps htt
 	json.NewEncoder(w).Encode(struct{ Data []string `json:"data"` }{Data: out})
 }
 
+// Returns the identifiers of all of the crash reports stored for a server, with the most
+// recent report listed first.
+func (rt *Router) routeServerCrashReports(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	ids, err := s.CrashReports()
+	if err != nil {
+		zap.S().Errorw("failed to list server crash reports", zap.String("server", s.Uuid), zap.Error(err))
+
+		http.Error(w, "failed to list crash reports", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct{ Data []string `json:"data"` }{Data: ids})
+}
+
+// Returns the contents of a single crash report for a server as a downloadable file.
+func (rt *Router) routeServerCrashReport(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	b, err := s.ReadCrashReport(ps.ByName("report"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			zap.S().Errorw("failed to read server crash report", zap.String("server", s.Uuid), zap.String("report", ps.ByName("report")), zap.Error(err))
+
+			http.Error(w, "failed to read crash report", http.StatusInternalServerError)
+			return
+		}
+
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=crash-"+ps.ByName("report")+".json")
+	w.Write(b)
+}
+
 // Handle a request to get the contents of a file on the server.
 func (rt *Router) routeServerFileRead(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	s := rt.GetServer(ps.ByName("server"))
@@ -398,7 +545,7 @@ func (rt *Router) routeServerSendCommand(w http.ResponseWriter, r *http.Request,
 	s := rt.GetServer(ps.ByName("server"))
 	defer r.Body.Close()
 
-	if running, err := s.Environment.IsRunning(); !running || err != nil {
+	if running, err := s.Environment.IsRunning(r.Context()); !running || err != nil {
 		http.Error(w, "cannot send commands to a stopped instance", http.StatusBadGateway)
 		return
 	}
@@ -424,11 +571,30 @@ func (rt *Router) routeServerInstall(w http.ResponseWriter, r *http.Request, ps
 	s := rt.GetServer(ps.ByName("server"))
 	defer r.Body.Close()
 
-	go func (serv *server.Server) {
-		if err := serv.Install(); err != nil {
+	ok := rt.runTask(func() {
+		if err := s.Install(rt.ctx); err != nil {
 			zap.S().Errorw("failed to execute server installation process", zap.String("server", s.Uuid), zap.Error(err))
 		}
-	}(s)
+	})
+
+	if !ok {
+		http.Error(w, errShuttingDown, http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Cancels the installation process that is currently running for a server. The server will
+// be left in the install failed state once the process has been stopped.
+func (rt *Router) routeServerCancelInstall(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	if !s.CancelInstall() {
+		http.Error(w, "server is not being installed", http.StatusConflict)
+		return
+	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -468,13 +634,28 @@ func (rt *Router) routeCreateServer(w http.ResponseWriter, r *http.Request, ps h
 	// Begin the installation process in the background to not block the request
 	// cycle. If there are any errors they will be logged and communicated back
 	// to the Panel where a reinstall may take place.
-	go func(i *installer.Installer) {
-		i.Execute()
+	ok := rt.runTask(func() {
+		inst.Execute(rt.ctx)
 
-		if err := i.Server().Install(); err != nil {
-			zap.S().Errorw("failed to run install process for server", zap.String("server", i.Uuid()), zap.Error(err))
+		if err := inst.Server().Install(rt.ctx); err != nil {
+			zap.S().Errorw("failed to run install process for server", zap.String("server", inst.Uuid()), zap.Error(err))
 		}
-	}(inst)
+	})
+
+	// The server is removed again so that it is not loaded without having been installed
+	// when the daemon next boots, the Panel can retry the request once the daemon is back.
+	if !ok {
+		server.GetServers().Remove(func(s *server.Server) bool {
+			return s == inst.Server()
+		})
+
+		if err := server.GetStore().Delete(inst.Uuid()); err != nil {
+			zap.S().Warnw("failed to delete server configuration for server that was not created", zap.String("server", inst.Uuid()), zap.Error(err))
+		}
+
+		http.Error(w, errShuttingDown, http.StatusServiceUnavailable)
+		return
+	}
 
 	w.WriteHeader(http.StatusAccepted)
 }
@@ -493,19 +674,92 @@ func (rt *Router) routeSystemInformation(w http.ResponseWriter, r *http.Request,
 	json.NewEncoder(w).Encode(s)
 }
 
+// Reloads the configuration file from the disk and returns the settings that were changed
+// but will not be applied until the daemon is restarted.
+func (rt *Router) routeSystemReload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	defer r.Body.Close()
+
+	restart, err := reloadConfiguration()
+	if err != nil {
+		http.Error(w, "failed to reload configuration: "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if restart == nil {
+		restart = []string{}
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		RestartRequired []string `json:"restart_required"`
+	}{
+		RestartRequired: restart,
+	})
+}
+
+type revokeTokensRequest struct {
+	// The IDs of the tokens to revoke.
+	Tokens []string `json:"tokens"`
+
+	// The IDs of the users to revoke all existing tokens for.
+	Users []json.Number `json:"users"`
+}
+
+// Revokes tokens issued by the Panel, either individually or for every token issued to a
+// user up until now.
+func (rt *Router) routeRevokeTokens(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	defer r.Body.Close()
+
+	var data revokeTokensRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, "could not parse revocations from request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	users := make([]string, len(data.Users))
+	for i, u := range data.Users {
+		users[i] = u.String()
+	}
+
+	if err := revoked.Revoke(data.Tokens, users); err != nil {
+		zap.S().Errorw("failed to persist token revocations", zap.Error(err))
+
+		http.Error(w, "failed to persist token revocations", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (rt *Router) routeServerDelete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	s := rt.GetServer(ps.ByName("server"))
 	defer r.Body.Close()
 
 	// Immediately suspend the server to prevent a user from attempting
 	// to start it while this process is running.
+	suspended := s.Suspended
 	s.Suspended = true
 
 	zap.S().Infow("processing server deletion request", zap.String("server", s.Uuid))
+
+	// Stop any installation process that is still running for the server so that it does
+	// not continue writing files into a directory that is about to be removed.
+	s.CancelInstall()
+
+	// Wait for any power action that is being processed to finish so that it does not
+	// recreate or start the environment while it is being destroyed. Actions queued behind
+	// this one will fail to start the server since it is now suspended.
+	if err := s.AcquirePowerLock(true); err != nil {
+		s.Suspended = suspended
+
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	defer s.ReleasePowerLock()
+
 	// Destroy the environment; in Docker this will handle a running container and
 	// forcibly terminate it before removing the container, so we do not need to handle
 	// that here.
-	if err := s.Environment.Destroy(); err != nil {
+	if err := s.Environment.Destroy(r.Context()); err != nil {
 		zap.S().Errorw("failed to destroy server environment", zap.Error(errors.WithStack(err)))
 
 		http.Error(w, "failed to destroy server environment", http.StatusInternalServerError)
@@ -531,11 +785,19 @@ func (rt *Router) routeServerDelete(w http.ResponseWriter, r *http.Request, ps h
 
 	s = nil
 
-	// Remove the configuration file stored on the Daemon for this server.
+	// Remove the configuration stored on the Daemon for this server.
 	go func(u string) {
-		if err := os.Remove("data/servers/" + u + ".yml"); err != nil {
+		if err := server.GetStore().Delete(u); err != nil {
 			zap.S().Warnw("failed to delete server configuration file on deletion", zap.String("server", u), zap.Error(errors.WithStack(err)))
 		}
+
+		if err := os.Remove(server.CachedConfigurationPath(u)); err != nil && !os.IsNotExist(err) {
+			zap.S().Warnw("failed to delete cached panel configuration on deletion", zap.String("server", u), zap.Error(errors.WithStack(err)))
+		}
+
+		if err := os.RemoveAll(server.CrashReportPath(u)); err != nil {
+			zap.S().Warnw("failed to delete crash reports on deletion", zap.String("server", u), zap.Error(errors.WithStack(err)))
+		}
 	}(uuid)
 
 	w.WriteHeader(http.StatusAccepted)
@@ -557,24 +819,31 @@ func (rt *Router) ConfigureRouter() *httprouter.Router {
 	})
 
 	router.GET("/", rt.routeIndex)
-	router.GET("/api/system", rt.AuthenticateToken(rt.routeSystemInformation))
-	router.GET("/api/servers", rt.AuthenticateToken(rt.routeAllServers))
-	router.GET("/api/servers/:server", rt.AuthenticateRequest(rt.routeServer))
+	router.GET("/api/system", rt.AuthenticateRequest(PermissionAdminSystem, rt.routeSystemInformation))
+	router.POST("/api/system/reload", rt.AuthenticateRequest(PermissionAdminSystem, rt.routeSystemReload))
+	router.POST("/api/system/tokens/revoke", rt.AuthenticateRequest(PermissionAdminSystem, rt.routeRevokeTokens))
+	router.GET("/api/servers", rt.AuthenticateRequest(PermissionAdminServers, rt.routeAllServers))
+	router.GET("/api/servers/:server", rt.AuthenticateRequest(PermissionServerView, rt.routeServer))
 	router.GET("/api/servers/:server/ws", rt.AuthenticateServer(rt.routeWebsocket))
-	router.GET("/api/servers/:server/logs", rt.AuthenticateRequest(rt.routeServerLogs))
-	router.GET("/api/servers/:server/files/contents", rt.AuthenticateRequest(rt.routeServerFileRead))
-	router.GET("/api/servers/:server/files/list-directory", rt.AuthenticateRequest(rt.routeServerListDirectory))
-	router.PUT("/api/servers/:server/files/rename", rt.AuthenticateRequest(rt.routeServerRenameFile))
-	router.POST("/api/servers", rt.AuthenticateToken(rt.routeCreateServer))
-	router.POST("/api/servers/:server/install", rt.AuthenticateRequest(rt.routeServerInstall))
-	router.POST("/api/servers/:server/files/copy", rt.AuthenticateRequest(rt.routeServerCopyFile))
-	router.POST("/api/servers/:server/files/write", rt.AuthenticateRequest(rt.routeServerWriteFile))
-	router.POST("/api/servers/:server/files/create-directory", rt.AuthenticateRequest(rt.routeServerCreateDirectory))
-	router.POST("/api/servers/:server/files/delete", rt.AuthenticateRequest(rt.routeServerDeleteFile))
-	router.POST("/api/servers/:server/power", rt.AuthenticateRequest(rt.routeServerPower))
-	router.POST("/api/servers/:server/commands", rt.AuthenticateRequest(rt.routeServerSendCommand))
-	router.PATCH("/api/servers/:server", rt.AuthenticateRequest(rt.routeServerUpdate))
-	router.DELETE("/api/servers/:server", rt.AuthenticateRequest(rt.routeServerDelete))
+	router.GET("/api/servers/:server/logs", rt.AuthenticateRequest(PermissionConsoleRead, rt.routeServerLogs))
+	router.GET("/api/servers/:server/crashes", rt.AuthenticateRequest(PermissionConsoleRead, rt.routeServerCrashReports))
+	router.GET("/api/servers/:server/crashes/:report", rt.AuthenticateRequest(PermissionConsoleRead, rt.routeServerCrashReport))
+	router.GET("/api/servers/:server/files/contents", rt.AuthenticateRequest(PermissionFileRead, rt.routeServerFileRead))
+	router.GET("/api/servers/:server/files/list-directory", rt.AuthenticateRequest(PermissionFileRead, rt.routeServerListDirectory))
+	router.PUT("/api/servers/:server/files/rename", rt.AuthenticateRequest(PermissionFileWrite, rt.routeServerRenameFile))
+	router.POST("/api/servers", rt.AuthenticateRequest(PermissionAdminServers, rt.routeCreateServer))
+	router.POST("/api/servers/:server/install", rt.AuthenticateRequest(PermissionAdminInstall, rt.routeServerInstall))
+	router.DELETE("/api/servers/:server/install", rt.AuthenticateRequest(PermissionAdminInstall, rt.routeServerCancelInstall))
+	router.POST("/api/servers/:server/files/copy", rt.AuthenticateRequest(PermissionFileWrite, rt.routeServerCopyFile))
+	router.POST("/api/servers/:server/files/write", rt.AuthenticateRequest(PermissionFileWrite, rt.routeServerWriteFile))
+	router.POST("/api/servers/:server/files/create-directory", rt.AuthenticateRequest(PermissionFileWrite, rt.routeServerCreateDirectory))
+	router.POST("/api/servers/:server/files/delete", rt.AuthenticateRequest(PermissionFileDelete, rt.routeServerDeleteFile))
+	// The permission needed for a power action depends on the action being sent, so it is
+	// checked by the route itself.
+	router.POST("/api/servers/:server/power", rt.AuthenticateRequest("", rt.routeServerPower))
+	router.POST("/api/servers/:server/commands", rt.AuthenticateRequest(PermissionConsoleWrite, rt.routeServerSendCommand))
+	router.PATCH("/api/servers/:server", rt.AuthenticateRequest(PermissionAdminUpdate, rt.routeServerUpdate))
+	router.DELETE("/api/servers/:server", rt.AuthenticateRequest(PermissionAdminDelete, rt.routeServerDelete))
 
 	return router
 }
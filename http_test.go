@@ -0,0 +1,134 @@
+package main
+
+import (
+	"github.com/creasty/defaults"
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/gorilla/websocket"
+	"github.com/pterodactyl/wings/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testServerUuid = "0f2d5cf6-7bd4-4b0e-9a4c-8f4c5e1a2b3c"
+
+const testAuthenticationToken = "test-token"
+
+// Sets the default configuration, using a known authentication token, for the duration of
+// a test. The returned function restores the previous configuration.
+func useTestConfiguration(t *testing.T) func() {
+	t.Helper()
+
+	c := new(config.Configuration)
+	if err := defaults.Set(c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.AuthenticationToken = testAuthenticationToken
+
+	previous := config.Get()
+	config.Set(c)
+
+	return func() {
+		config.Set(previous)
+	}
+}
+
+// Returns a token for the test server granting the given permissions, signed the same way
+// as the tokens issued by the Panel.
+func serverToken(t *testing.T, permissions ...string) string {
+	t.Helper()
+
+	b, err := jwt.Sign(&WebsocketTokenPayload{
+		Payload: jwt.Payload{
+			ExpirationTime: jwt.NumericDate(time.Now().Add(time.Minute)),
+			IssuedAt:       jwt.NumericDate(time.Now()),
+		},
+		UserID:      "1",
+		ServerUUID:  testServerUuid,
+		Permissions: permissions,
+	}, jwt.NewHS256([]byte(testAuthenticationToken)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestServerTokenCannotUseAdministrativeRoutes(t *testing.T) {
+	defer useTestConfiguration(t)()
+
+	router := NewRouter(websocket.Upgrader{}).ConfigureRouter()
+
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{"PATCH", "/api/servers/" + testServerUuid},
+		{"DELETE", "/api/servers/" + testServerUuid},
+		{"POST", "/api/servers/" + testServerUuid + "/install"},
+		{"DELETE", "/api/servers/" + testServerUuid + "/install"},
+	}
+
+	for _, granted := range []string{"*", "admin.*", "admin.update", "admin.delete", "admin.install"} {
+		token := serverToken(t, granted)
+
+		for _, rt := range routes {
+			r := httptest.NewRequest(rt.method, rt.path, nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("expected a token granting %q to get a 403 for %s %s, got %d", granted, rt.method, rt.path, w.Code)
+			}
+		}
+	}
+}
+
+func TestSystemReloadRejectsInvalidConfiguration(t *testing.T) {
+	defer useTestConfiguration(t)()
+
+	dir, err := ioutil.TempDir("", "wings-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previousPath := configPath
+	configPath = filepath.Join(dir, "config.yml")
+	defer func() {
+		configPath = previousPath
+	}()
+
+	if err := ioutil.WriteFile(configPath, []byte("throttles:\n  kill_at_count: 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config.Get()
+	router := NewRouter(websocket.Upgrader{}).ConfigureRouter()
+
+	r := httptest.NewRequest("POST", "/api/system/reload", nil)
+	r.Header.Set("Authorization", "Bearer "+testAuthenticationToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected a 422 response, got %d", w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), "throttles.kill_at_count") {
+		t.Errorf("expected the response to include the problems with the configuration, got %q", w.Body.String())
+	}
+
+	if config.Get() != previous {
+		t.Error("expected the current configuration to be kept")
+	}
+}
@@ -1,9 +1,11 @@
 package installer
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/asaskevich/govalidator"
 	"github.com/buger/jsonparser"
+	"github.com/creasty/defaults"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/config"
 	"github.com/pterodactyl/wings/server"
@@ -31,7 +33,6 @@ func New(data []byte) (*Installer, error) {
 	s := &server.Server{
 		Uuid:       getString(data, "uuid"),
 		Suspended:  false,
-		State:      server.ProcessOfflineState,
 		Invocation: getString(data, "invocation"),
 		EnvVars:    make(map[string]string),
 		Build: server.BuildSettings{
@@ -46,6 +47,12 @@ func New(data []byte) (*Installer, error) {
 		},
 	}
 
+	// Apply the default values for any fields that were not set above, such as the
+	// crash detection settings for the server.
+	if err := defaults.Set(s); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	s.Init()
 
 	s.Allocations.DefaultMapping.Ip = getString(data, "allocations", "default", "ip")
@@ -103,7 +110,7 @@ func (i *Installer) Server() *server.Server {
 // Executes the installer process, creating the server and running through the
 // associated installation process based on the parameters passed through for
 // the server instance.
-func (i *Installer) Execute() {
+func (i *Installer) Execute(ctx context.Context) {
 	zap.S().Debugw("creating required server data directory", zap.String("server", i.Uuid()))
 	if err := os.MkdirAll(path.Join(config.Get().System.Data, i.Uuid()), 0755); err != nil {
 		zap.S().Errorw("failed to create server data directory", zap.String("server", i.Uuid()), zap.Error(errors.WithStack(err)))
@@ -117,7 +124,7 @@ func (i *Installer) Execute() {
 
 
 	zap.S().Debugw("creating required environment for server instance", zap.String("server", i.Uuid()))
-	if err := i.server.Environment.Create(); err != nil {
+	if err := i.server.Environment.Create(ctx); err != nil {
 		zap.S().Errorw("failed to create environment for server", zap.String("server", i.Uuid()), zap.Error(err))
 		return
 	}
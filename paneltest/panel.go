@@ -0,0 +1,217 @@
+// Package paneltest provides a fake Panel that serves the remote API used by the daemon.
+// Responses for each endpoint are configured by the test using the panel, and all of the
+// requests received are recorded so that the payloads sent by the daemon can be checked.
+package paneltest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/creasty/defaults"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// The Accept header that the Panel requires to be sent with all remote API requests.
+const AcceptHeader = "application/vnd.pterodactyl.v1+json"
+
+// Defines a request that was received by the fake Panel.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// Unmarshals the body of the request into the given value.
+func (r Request) Decode(v interface{}) error {
+	return json.Unmarshal(r.Body, v)
+}
+
+// Defines the response returned by the fake Panel for an endpoint.
+type Response struct {
+	Status int
+	Body   []byte
+}
+
+// A fake Panel running on a local HTTP server.
+type Panel struct {
+	*httptest.Server
+
+	// The token that must be provided in the Authorization header of each request.
+	Token string
+
+	mu        sync.Mutex
+	responses map[string]Response
+	queued    map[string][]Response
+	requests  []Request
+}
+
+// Starts a new fake Panel that requires the given token to be sent with requests.
+func New(token string) *Panel {
+	p := &Panel{
+		Token:     token,
+		responses: make(map[string]Response),
+		queued:    make(map[string][]Response),
+	}
+
+	p.Server = httptest.NewServer(http.HandlerFunc(p.handle))
+
+	return p
+}
+
+// Returns a daemon configuration that points at the fake Panel. This can be passed to
+// config.Set to have all requests made by the api package sent to this panel. The delay
+// between retried requests is kept short so that tests are not slowed down by them.
+func (p *Panel) Configuration() *config.Configuration {
+	c := new(config.Configuration)
+	if err := defaults.Set(c); err != nil {
+		panic(err)
+	}
+
+	c.PanelLocation = p.URL
+	c.AuthenticationToken = p.Token
+	c.RemoteQuery.RetryDelay = 1
+	c.RemoteQuery.MaxRetryDelay = 5
+
+	return c
+}
+
+// Sets the response returned for a method and path under /api/remote, for example
+// "GET" and "/servers/<uuid>". The body is marshaled to JSON unless it is already
+// a byte slice or string, in which case it is returned as-is.
+func (p *Panel) Handle(method string, path string, status int, body interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.responses[key(method, path)] = response(status, body)
+}
+
+// Queues a response that is only returned once for a method and path. Queued responses
+// are returned in order before falling back to the response set using Handle.
+func (p *Panel) Queue(method string, path string, status int, body interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	k := key(method, path)
+	p.queued[k] = append(p.queued[k], response(status, body))
+}
+
+// Sets an error response for a method and path, using the same error bag format that
+// the Panel returns for failed requests.
+func (p *Panel) HandleError(method string, path string, status int, code string, detail string) {
+	p.Handle(method, path, status, ErrorBag(status, code, detail))
+}
+
+// Returns all of the requests that have been received by the panel.
+func (p *Panel) Requests() []Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]Request(nil), p.requests...)
+}
+
+// Returns the most recent request received by the panel for the given method and path.
+func (p *Panel) LastRequest(method string, path string) (Request, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := len(p.requests) - 1; i >= 0; i-- {
+		if key(p.requests[i].Method, p.requests[i].Path) == key(method, path) {
+			return p.requests[i], true
+		}
+	}
+
+	return Request{}, false
+}
+
+// Returns an error bag in the format used by the Panel.
+func ErrorBag(status int, code string, detail string) api.RequestErrorBag {
+	return api.RequestErrorBag{
+		Errors: []api.RequestError{{
+			Code:   code,
+			Status: fmt.Sprintf("%d", status),
+			Detail: detail,
+		}},
+	}
+}
+
+// Builds a response for the given body. The body is marshaled to JSON unless it is already
+// a byte slice or string.
+func response(status int, body interface{}) Response {
+	var b []byte
+	switch v := body.(type) {
+	case nil:
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		var err error
+		if b, err = json.Marshal(v); err != nil {
+			panic(err)
+		}
+	}
+
+	return Response{Status: status, Body: b}
+}
+
+func (p *Panel) handle(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+
+	path := "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/remote"), "/")
+
+	p.mu.Lock()
+	p.requests = append(p.requests, Request{
+		Method: r.Method,
+		Path:   path,
+		Header: r.Header,
+		Body:   b,
+	})
+	k := key(r.Method, path)
+	res, ok := p.responses[k]
+	if q := p.queued[k]; len(q) > 0 {
+		res, ok = q[0], true
+		p.queued[k] = q[1:]
+	}
+	p.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Header.Get("Accept") != AcceptHeader {
+		p.write(w, http.StatusNotAcceptable, ErrorBag(http.StatusNotAcceptable, "NotAcceptableHttpException", "The requested format is not supported."))
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+p.Token {
+		p.write(w, http.StatusForbidden, ErrorBag(http.StatusForbidden, "AccessDeniedHttpException", "You are not authorized to access this resource."))
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/api/remote/") || !ok {
+		p.write(w, http.StatusNotFound, ErrorBag(http.StatusNotFound, "NotFoundHttpException", "The requested resource does not exist on this server."))
+		return
+	}
+
+	if res.Status == 0 {
+		res.Status = http.StatusOK
+	}
+
+	w.WriteHeader(res.Status)
+	w.Write(res.Body)
+}
+
+func (p *Panel) write(w http.ResponseWriter, status int, v interface{}) {
+	b, _ := json.Marshal(v)
+
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func key(method string, path string) string {
+	return strings.ToUpper(method) + " /" + strings.Trim(path, "/")
+}
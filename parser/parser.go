@@ -71,6 +71,31 @@ func (cfr *ConfigurationFileReplacement) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Encodes the replacement using the same value type that it was originally decoded with,
+// so that a replacement can be stored and decoded again without changing its type.
+func (cfr ConfigurationFileReplacement) MarshalJSON() ([]byte, error) {
+	m, err := json.Marshal(cfr.Match)
+	if err != nil {
+		return nil, err
+	}
+
+	var v []byte
+	switch cfr.ValueType {
+	case jsonparser.Number, jsonparser.Boolean:
+		v = []byte(cfr.Value)
+	case jsonparser.String:
+		// Decoded string values are kept exactly as they were in the original JSON,
+		// including any escape sequences, so they only need to be wrapped in quotes.
+		v = []byte(`"` + cfr.Value + `"`)
+	default:
+		if v, err = json.Marshal(cfr.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	return []byte(`{"match":` + string(m) + `,"value":` + string(v) + `}`), nil
+}
+
 // Parses a given configuration file and updates all of the values within as defined
 // in the API response from the Panel.
 func (f *ConfigurationFile) Parse(path string, internal bool) error {
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"github.com/pterodactyl/wings/server"
+	"strings"
+)
+
+// A permission that can be granted to a token issued by the Panel for a server. Every API
+// route and websocket event declares the permission it requires.
+//
+// Permissions are grouped by the prefix before the dot, and a token can be granted every
+// permission in a group using a wildcard such as "file.*", or every permission using "*".
+// Wildcards never grant the administrative permissions, which must be granted by name.
+type Permission string
+
+const (
+	PermissionWebsocketConnect Permission = "websocket.connect"
+	PermissionServerView       Permission = "server.view"
+
+	PermissionConsoleRead  Permission = "console.read"
+	PermissionConsoleWrite Permission = "console.write"
+
+	PermissionControlStart   Permission = "control.start"
+	PermissionControlStop    Permission = "control.stop"
+	PermissionControlRestart Permission = "control.restart"
+
+	PermissionFileRead   Permission = "file.read"
+	PermissionFileWrite  Permission = "file.write"
+	PermissionFileDelete Permission = "file.delete"
+
+	PermissionInstallView Permission = "install.view"
+
+	// Administrative permissions, these are normally only used by the Panel itself through
+	// the global token for the daemon. API routes requiring one of these only accept the
+	// global token, a token issued for a server can only use them for the websocket.
+	PermissionAdminErrors  Permission = "admin.errors"
+	PermissionAdminInstall Permission = "admin.install"
+	PermissionAdminUpdate  Permission = "admin.update"
+	PermissionAdminDelete  Permission = "admin.delete"
+	PermissionAdminServers Permission = "admin.servers"
+	PermissionAdminSystem  Permission = "admin.system"
+)
+
+// The permissions used by tokens issued before the permission catalogue existed, and the
+// permissions that each of them grants.
+var legacyPermissions = map[string][]Permission{
+	"connect":         {PermissionWebsocketConnect, PermissionServerView, PermissionConsoleRead},
+	"send-command":    {PermissionConsoleWrite},
+	"send-power":      {PermissionControlStart, PermissionControlStop, PermissionControlRestart},
+	"receive-errors":  {PermissionAdminErrors},
+	"receive-install": {PermissionInstallView},
+	"list-files":      {PermissionFileRead},
+	"edit-files":      {PermissionFileRead, PermissionFileWrite},
+	"save-files":      {PermissionFileWrite},
+	"create-files":    {PermissionFileWrite},
+	"move-files":      {PermissionFileWrite},
+	"delete-files":    {PermissionFileDelete},
+}
+
+// The group that the administrative permissions belong to.
+const adminPermissionGroup = "admin."
+
+// Determines if this is one of the administrative permissions.
+func (p Permission) isAdmin() bool {
+	return strings.HasPrefix(string(p), adminPermissionGroup)
+}
+
+// Determines if a permission string from a token grants this permission.
+func (p Permission) GrantedBy(granted string) bool {
+	if granted == string(p) {
+		return true
+	}
+
+	for _, l := range legacyPermissions[granted] {
+		if l == p {
+			return true
+		}
+	}
+
+	if p.isAdmin() {
+		return false
+	}
+
+	return granted == "*" || (strings.HasSuffix(granted, ".*") && strings.HasPrefix(string(p), strings.TrimSuffix(granted, "*")))
+}
+
+// Returns the permission required to send a power action to a server.
+func powerPermission(action string) Permission {
+	switch action {
+	case server.PowerActionStart:
+		return PermissionControlStart
+	case server.PowerActionRestart:
+		return PermissionControlRestart
+	}
+
+	return PermissionControlStop
+}
+
+// The permission required to send each inbound websocket event.
+var websocketEventPermissions = map[string]Permission{
+	SendServerLogsEvent: PermissionConsoleRead,
+	SendCommandEvent:    PermissionConsoleWrite,
+}
+
+// Returns the permission required to handle an inbound websocket message, and false if the
+// message does not require one. Power actions require the permission for the specific
+// action being sent.
+func websocketPermission(m WebsocketMessage) (Permission, bool) {
+	if m.Event == SetStateEvent {
+		if len(m.Args) == 0 {
+			return PermissionControlStop, true
+		}
+
+		return powerPermission(m.Args[0]), true
+	}
+
+	p, ok := websocketEventPermissions[m.Event]
+
+	return p, ok
+}
+
+// The permission required to receive each outbound websocket event.
+var websocketOutboundPermissions = map[string]Permission{
+	server.ConsoleOutputEvent: PermissionConsoleRead,
+	server.InstallOutputEvent: PermissionInstallView,
+	server.DaemonMessageEvent: PermissionConsoleRead,
+	server.StatsEvent:         PermissionServerView,
+	server.StatusEvent:        PermissionServerView,
+}
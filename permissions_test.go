@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestPermissionGrantedBy(t *testing.T) {
+	tests := []struct {
+		permission Permission
+		granted    string
+		expected   bool
+	}{
+		{PermissionFileRead, "file.read", true},
+		{PermissionFileRead, "file.*", true},
+		{PermissionFileRead, "*", true},
+		{PermissionFileRead, "control.*", false},
+		{PermissionFileRead, "edit-files", true},
+		{PermissionFileWrite, "edit-files", true},
+		{PermissionFileDelete, "edit-files", false},
+		{PermissionAdminErrors, "admin.errors", true},
+		{PermissionAdminErrors, "receive-errors", true},
+		{PermissionAdminErrors, "admin.*", false},
+		{PermissionAdminDelete, "*", false},
+		{PermissionAdminUpdate, "admin.*", false},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.permission.GrantedBy(tt.granted); actual != tt.expected {
+			t.Errorf("expected %q granting %s to be %v, got %v", tt.granted, tt.permission, tt.expected, actual)
+		}
+	}
+}
@@ -11,7 +11,7 @@ import (
 func (s *Server) UpdateConfigurationFiles() {
 	wg := new(sync.WaitGroup)
 
-	for _, v := range s.processConfiguration.ConfigurationFiles {
+	for _, v := range s.ProcessConfiguration().ConfigurationFiles {
 		wg.Add(1)
 
 		go func(f parser.ConfigurationFile, server *Server) {
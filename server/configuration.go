@@ -1,31 +1,124 @@
 package server
 
 import (
+	"encoding/json"
 	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/atomicfile"
+	"github.com/pterodactyl/wings/config"
 	"gopkg.in/yaml.v2"
-	"os"
+	"io/ioutil"
+	"path/filepath"
 )
 
-// Writes the server configuration to the disk. The saved configuration will be returned
+// Writes the server configuration to the store. The saved configuration will be returned
 // back to the calling function to use if desired.
+//
+// Writes that happen while another is in progress are combined into a single write of the
+// latest configuration.
 func (s *Server) WriteConfigurationToDisk() ([]byte, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	var uuid string
 
-	f, err := os.Create("data/servers/" + s.Uuid + ".yml")
+	return s.configWriter.WriteFunc(func() ([]byte, error) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		// The state is copied under the state lock while the configuration is encoded, see
+		// MarshalYAML. Each state change starts another write once it has been made, so the
+		// latest state is always the one that ends up on the disk.
+		uuid = s.Uuid
+		b, err := yaml.Marshal(&s)
+
+		return b, errors.WithStack(err)
+	}, func(b []byte) error {
+		return GetStore().Put(uuid, b)
+	})
+}
+
+// The fields of a server without any of its methods, used to encode and decode a server
+// alongside its state, which is not exported since it must only be accessed under a lock.
+type serverFields Server
+
+// Encodes the server for the API, including its current state and resource usage.
+func (s *Server) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		*serverFields
+		State     ProcessState  `json:"state"`
+		Resources ResourceUsage `json:"resources"`
+	}{
+		serverFields: (*serverFields)(s),
+		State:        s.GetState(),
+		Resources:    s.GetResources(),
+	})
+}
+
+// Encodes the server for the store, including its current state. The server is copied while
+// holding the state lock, so the snapshot that is encoded always has the state it was in at
+// a single point in time. The rest of the persisted fields are only changed while holding
+// the server mutex, which the caller must hold.
+func (s *Server) MarshalYAML() (interface{}, error) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	return struct {
+		serverFields `yaml:",inline"`
+		State        ProcessState `yaml:"state"`
+	}{
+		serverFields: serverFields(*s),
+		State:        s.state,
+	}, nil
+}
+
+// Decodes a server read from the store, restoring the state it was last in. This is only
+// done before the server is in use, so the state is set directly.
+func (s *Server) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal((*serverFields)(s)); err != nil {
+		return err
+	}
+
+	var v struct {
+		State ProcessState `yaml:"state"`
+	}
+
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+
+	if v.State != "" {
+		s.state = v.State
+	}
+
+	return nil
+}
+
+// Returns the path to the file used to cache the last configuration received from the
+// Panel for a server. This is stored in the root directory of the server store.
+func CachedConfigurationPath(uuid string) string {
+	return filepath.Join(config.Get().System.Store.Root, uuid+".panel.json")
+}
+
+// Persists the configuration received from the Panel so that it can be used to start the
+// server if the Panel cannot be reached at a later point.
+func (s *Server) writeCachedConfiguration(cfg *api.ServerConfigurationResponse) error {
+	b, err := json.Marshal(cfg)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		return errors.WithStack(err)
 	}
-	defer f.Close()
 
-	b, err := yaml.Marshal(&s)
+	return atomicfile.WriteFile(CachedConfigurationPath(s.Uuid), b, 0600)
+}
+
+// Reads the last configuration received from the Panel for the server.
+func (s *Server) readCachedConfiguration() (*api.ServerConfigurationResponse, error) {
+	b, err := ioutil.ReadFile(CachedConfigurationPath(s.Uuid))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	if _, err := f.Write(b); err != nil {
+	cfg := new(api.ServerConfigurationResponse)
+	if err := json.Unmarshal(b, cfg); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	return b, nil
-}
\ No newline at end of file
+	return cfg, nil
+}
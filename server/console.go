@@ -3,7 +3,9 @@ package server
 import (
 	"fmt"
 	"github.com/mitchellh/colorstring"
+	"go.uber.org/zap"
 	"io"
+	"strings"
 )
 
 type Console struct {
@@ -24,11 +26,51 @@ func (c Console) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+// Returns the history of console output for the server. If nothing has been written to it
+// since the daemon was started it is filled from the end of the server log first, so that
+// output from before a restart of the daemon is still available for a server that is not
+// running.
+func (s *Server) ConsoleHistory() *ConsoleHistory {
+	s.seedConsoleHistory()
+
+	return s.console
+}
+
+// Sends a line of output to the server console, adding it to the console history so
+// that it can be replayed later.
+func (s *Server) PublishConsoleOutput(line string) {
+	s.console.Write(line, func(l ConsoleLine) {
+		s.Events().PublishEvent(Event{Topic: ConsoleOutputEvent, Data: l.Line, Sequence: l.Sequence})
+	})
+}
+
 // Sends output to the server console formatted to appear correctly as being sent
 // from Wings.
 func (s *Server) PublishConsoleOutputFromDaemon(data string) {
-	s.Events().Publish(
-		ConsoleOutputEvent,
+	s.PublishConsoleOutput(
 		colorstring.Color(fmt.Sprintf("[yellow][bold][Pterodactyl Daemon]:[default] %s", data)),
 	)
 }
+
+// Fills the console history from the end of the server log if nothing has been written
+// to it yet, which is the case when attaching to a server that was already running when
+// the daemon was started, or reading the history of one that has not been started since.
+func (s *Server) seedConsoleHistory() {
+	if s.console.Sequence() > 0 {
+		return
+	}
+
+	lines, err := s.Environment.Readlog(1024 * 16)
+	if err != nil {
+		zap.S().Debugw("could not read server log to fill console history", zap.String("server", s.Uuid), zap.Error(err))
+		return
+	}
+
+	// Lines read from the Docker log keep their trailing newline, which output written to
+	// the history as it is received does not have.
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, "\r\n")
+	}
+
+	s.console.Seed(lines)
+}
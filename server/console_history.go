@@ -0,0 +1,118 @@
+package server
+
+import (
+	"sync"
+)
+
+// A line of console output along with its sequence number. Sequence numbers start at 1
+// and increase by one for every line written to the console of a server.
+type ConsoleLine struct {
+	Sequence uint64 `json:"seq"`
+	Line     string `json:"line"`
+}
+
+// Keeps the most recent lines of console output for a server in memory so that they can
+// be replayed to clients, regardless of whether the server is running. Once the history
+// is full the oldest lines are discarded as new ones are written.
+type ConsoleHistory struct {
+	mu    sync.Mutex
+	lines []ConsoleLine
+
+	// The position in lines that the next line is written to, and the sequence number
+	// that it is given.
+	next     int
+	sequence uint64
+}
+
+// Creates a history that keeps up to size lines of console output.
+func NewConsoleHistory(size int) *ConsoleHistory {
+	if size < 1 {
+		size = 1
+	}
+
+	return &ConsoleHistory{lines: make([]ConsoleLine, 0, size)}
+}
+
+// Adds a line to the history and passes it, along with its sequence number, to the publish
+// function. The history is locked while publishing so that lines are always published in
+// the order of their sequence numbers.
+func (h *ConsoleHistory) Write(line string, publish func(ConsoleLine)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	l := h.add(line)
+	if publish != nil {
+		publish(l)
+	}
+}
+
+func (h *ConsoleHistory) add(line string) ConsoleLine {
+	h.sequence++
+
+	l := ConsoleLine{Sequence: h.sequence, Line: line}
+	if len(h.lines) < cap(h.lines) {
+		h.lines = append(h.lines, l)
+	} else {
+		h.lines[h.next] = l
+	}
+
+	h.next = (h.next + 1) % cap(h.lines)
+
+	return l
+}
+
+// Adds lines to the history without publishing them. This is only done if nothing has been
+// written to the history yet, and is used to fill it from the server log after the daemon
+// has been restarted. Returns false if the history already contains output.
+func (h *ConsoleHistory) Seed(lines []string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.sequence > 0 {
+		return false
+	}
+
+	for _, line := range lines {
+		h.add(line)
+	}
+
+	return true
+}
+
+// Returns the sequence number of the most recent line in the history, or 0 if nothing has
+// been written to it.
+func (h *ConsoleHistory) Sequence() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.sequence
+}
+
+// Returns the lines in the history that come after the given sequence number, oldest first.
+// Passing 0 returns the entire history. If lines after the sequence number have already
+// been discarded the returned lines start from the oldest line still in the history, which
+// clients can detect by checking the sequence number of the first line.
+//
+// Sequence numbers are not kept when the daemon is restarted, so a sequence number newer
+// than anything in the history is treated as coming from before a restart, and the entire
+// history is returned.
+func (h *ConsoleHistory) Since(sequence uint64) []ConsoleLine {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if sequence > h.sequence {
+		sequence = 0
+	}
+
+	n := int(h.sequence - sequence)
+	if n > len(h.lines) {
+		n = len(h.lines)
+	}
+
+	out := make([]ConsoleLine, n)
+	for i := 0; i < n; i++ {
+		out[i] = h.lines[(h.next-n+i+cap(h.lines))%cap(h.lines)]
+	}
+
+	return out
+}
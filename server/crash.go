@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/config"
@@ -13,8 +14,80 @@ type CrashDetection struct {
 	// can indicate that the server stopped unexpectedly.
 	Enabled bool `default:"true" json:"enabled" yaml:"enabled"`
 
-	// Tracks the time of the last server crash event.
-	lastCrash time.Time
+	// The maximum number of times that a server will be automatically restarted after
+	// crashing within the crash window. Once this is exceeded the server will be left
+	// offline until it is started again manually.
+	MaxRestarts int `default:"3" json:"max_restarts" yaml:"max_restarts"`
+
+	// The length of the crash window in seconds. Only crashes that occurred within this
+	// many seconds of the current crash count towards the restart limit.
+	Window int `default:"600" json:"window" yaml:"window"`
+
+	// The number of seconds to wait before restarting the server after the first crash
+	// in the window. This value is doubled for each additional crash in the window.
+	Backoff int `default:"5" json:"backoff" yaml:"backoff"`
+
+	// The maximum number of seconds to wait before restarting a crashed server, regardless
+	// of how many times it has crashed in the window.
+	MaxBackoff int `default:"300" json:"max_backoff" yaml:"max_backoff"`
+
+	// Exit codes that should never be treated as a crash. A process exiting with one of
+	// these codes will not be restarted, unless it was killed for running out of memory.
+	IgnoredExitCodes []int `json:"ignored_exit_codes" yaml:"ignored_exit_codes"`
+
+	// If set to false, a server process that is killed by the system for running out of
+	// memory will not be automatically restarted.
+	RestartOnOom bool `default:"true" json:"restart_on_oom" yaml:"restart_on_oom"`
+
+	// Tracks the times of the server crashes within the crash window. This is persisted
+	// with the server configuration so that it survives daemon restarts.
+	History []time.Time `json:"-" yaml:"history"`
+}
+
+// Determines if the given exit code is one that should never be treated as a crash.
+func (cd *CrashDetection) IsIgnoredExitCode(code uint32) bool {
+	for _, c := range cd.IgnoredExitCodes {
+		if c >= 0 && uint32(c) == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Records a crash at the given time and drops any crashes from the history that fall
+// outside of the crash window. Returns the number of crashes within the window, including
+// the one that was just recorded.
+func (cd *CrashDetection) recordCrash(t time.Time) int {
+	cutoff := t.Add(-time.Second * time.Duration(cd.Window))
+
+	h := make([]time.Time, 0, len(cd.History)+1)
+	for _, c := range cd.History {
+		if c.After(cutoff) {
+			h = append(h, c)
+		}
+	}
+
+	cd.History = append(h, t)
+
+	return len(cd.History)
+}
+
+// Returns the amount of time to wait before restarting a server that has crashed the
+// given number of times within the crash window.
+func (cd *CrashDetection) backoffFor(crashes int) time.Duration {
+	d := time.Second * time.Duration(cd.Backoff)
+	max := time.Second * time.Duration(cd.MaxBackoff)
+
+	for i := 1; i < crashes && d < max; i++ {
+		d *= 2
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
 }
 
 // Looks at the environment exit state to determine if the process exited cleanly or
@@ -25,15 +98,14 @@ type CrashDetection struct {
 // look at the exit state and check if it meets the criteria of being called a crash
 // by Wings.
 //
-// If the server is determined to have crashed, the process will be restarted and the
-// counter for the server will be incremented.
-//
-// @todo output event to server console
-func (s *Server) handleServerCrash() error {
+// If the server is determined to have crashed, the crash is recorded in the server's
+// history and the process will be restarted after a backoff period, so long as the
+// server has not exceeded the number of restarts allowed in the crash window.
+func (s *Server) handleServerCrash(ctx context.Context) error {
 	// No point in doing anything here if the server isn't currently offline, there
 	// is no reason to do a crash detection event. If the server crash detection is
 	// disabled we want to skip anything after this as well.
-	if s.State != ProcessOfflineState || !s.CrashDetection.Enabled {
+	if s.GetState() != ProcessOfflineState || !s.CrashDetection.Enabled {
 		if !s.CrashDetection.Enabled {
 			zap.S().Debugw("server triggered crash detection but handler is disabled for server process", zap.String("server", s.Uuid))
 
@@ -43,7 +115,7 @@ func (s *Server) handleServerCrash() error {
 		return nil
 	}
 
-	exitCode, oomKilled, err := s.Environment.ExitState()
+	exitCode, oomKilled, err := s.Environment.ExitState(ctx)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -56,20 +128,87 @@ func (s *Server) handleServerCrash() error {
 		return nil
 	}
 
+	if !oomKilled && s.CrashDetection.IsIgnoredExitCode(exitCode) {
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Server process exited with code %d, which is not treated as a crash.", exitCode))
+
+		return nil
+	}
+
 	s.PublishConsoleOutputFromDaemon("---------- Detected server process in a crashed state! ----------")
 	s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Exit code: %d", exitCode))
 	s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Out of memory: %t", oomKilled))
 
-	c := s.CrashDetection.lastCrash
-	// If the last crash time was within the last 60 seconds we do not want to perform
-	// an automatic reboot of the process. Return an error that can be handled.
-	if !c.IsZero() && c.Add(time.Second * 60).After(time.Now()) {
-		s.PublishConsoleOutputFromDaemon("Aborting automatic reboot: last crash occurred less than 60 seconds ago.")
+	if config.Get().System.CrashReports.Enabled {
+		if r, err := s.SaveCrashReport(ctx, exitCode, oomKilled); err != nil {
+			zap.S().Warnw("failed to save crash report for server", zap.String("server", s.Uuid), zap.Error(err))
+		} else {
+			s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Saved crash report: %s", r.Id))
+		}
+	}
+
+	// The history is persisted with the rest of the server configuration, so it must only
+	// be changed while holding the same lock used when writing that to the disk.
+	s.mutex.Lock()
+	crashes := s.CrashDetection.recordCrash(time.Now())
+	s.mutex.Unlock()
+
+	// Persist the crash history so that the restart limits continue to apply should the
+	// daemon be restarted while the server is crash looping.
+	if _, err := s.WriteConfigurationToDisk(); err != nil {
+		zap.S().Warnw("failed to write server crash history to disk", zap.String("server", s.Uuid), zap.Error(err))
+	}
+
+	if oomKilled && !s.CrashDetection.RestartOnOom {
+		s.PublishConsoleOutputFromDaemon("Aborting automatic restart: server is configured to not restart after running out of memory.")
+
+		return nil
+	}
+
+	if crashes > s.CrashDetection.MaxRestarts {
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf(
+			"Aborting automatic restart: server has crashed %d times in the last %d seconds.",
+			crashes,
+			s.CrashDetection.Window,
+		))
 
 		return &crashTooFrequent{}
 	}
 
-	s.CrashDetection.lastCrash = time.Now()
+	backoff := s.CrashDetection.backoffFor(crashes)
+	s.PublishConsoleOutputFromDaemon(fmt.Sprintf(
+		"Restarting server in %d seconds (crash %d of %d allowed in the last %d seconds).",
+		int(backoff.Seconds()),
+		crashes,
+		s.CrashDetection.MaxRestarts,
+		s.CrashDetection.Window,
+	))
+
+	t := time.NewTimer(backoff)
+	select {
+	case <-ctx.Done():
+		t.Stop()
+
+		return ctx.Err()
+	case <-t.C:
+	}
+
+	// Wait for any other power actions to finish processing before attempting to boot
+	// the server back up, otherwise we could end up racing with a start triggered by
+	// a user.
+	if err := s.AcquirePowerLock(true); err != nil {
+		return err
+	}
+	defer s.ReleasePowerLock()
+
+	// The server may have been started by a user while we were waiting, in which case
+	// there is nothing left to do here.
+	if s.GetState() != ProcessOfflineState {
+		s.PublishConsoleOutputFromDaemon("Aborting automatic restart: server is no longer offline.")
+
+		return nil
+	}
 
-	return s.Environment.Start()
-}
\ No newline at end of file
+	// The context is only used to stop waiting for the backoff when the daemon is shutting
+	// down, once the server is being started it is left to finish.
+	return s.HandlePowerAction(context.Background(), PowerActionStart)
+}
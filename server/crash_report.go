@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/atomicfile"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The format used to generate the identifier for a crash report. This is also used as
+// the name of the report file on the disk.
+const crashReportIdFormat = "20060102-150405.000"
+
+var crashReportIdRegex = regexp.MustCompile(`^\d{8}-\d{6}\.\d{3}$`)
+
+// Defines a crash report that is generated by the daemon each time a server process is
+// detected as having crashed. This captures as much context about the process at the time
+// of the crash as possible so that the crash can be diagnosed after the fact.
+type CrashReport struct {
+	Id        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	ExitCode  uint32    `json:"exit_code"`
+	OomKilled bool      `json:"oom_killed"`
+
+	// The last resource usage values collected for the server before the process exited.
+	Resources ResourceUsage `json:"resources"`
+
+	// A summary of the environment the server process was running in. For Docker this is
+	// the relevant information from inspecting the container.
+	Environment map[string]interface{} `json:"environment"`
+
+	// The last lines of console output from the server process.
+	Logs []string `json:"logs"`
+}
+
+// Returns the directory that crash reports for a server are stored in. Each server has its
+// own directory named after its UUID within the root directory of the server store.
+func CrashReportPath(uuid string) string {
+	return filepath.Join(config.Get().System.Store.Root, "crash_reports", uuid)
+}
+
+// Returns the directory that crash reports for the server are stored in.
+func (s *Server) crashReportPath() string {
+	return CrashReportPath(s.Uuid)
+}
+
+// Generates a new crash report for the server using the given exit state and writes it
+// to the disk. Once saved, the oldest reports for the server are removed until only the
+// configured number of reports remain.
+func (s *Server) SaveCrashReport(ctx context.Context, exitCode uint32, oomKilled bool) (*CrashReport, error) {
+	cfg := config.Get().System.CrashReports
+
+	now := time.Now().UTC()
+	r := &CrashReport{
+		Id:        now.Format(crashReportIdFormat),
+		Timestamp: now,
+		ExitCode:  exitCode,
+		OomKilled: oomKilled,
+		Resources: s.finalResources(),
+	}
+
+	// Failing to grab the environment summary or the logs shouldn't prevent the report
+	// from being generated, the information just won't be included.
+	if summary, err := s.Environment.Summary(ctx); err == nil {
+		r.Environment = summary
+	}
+
+	if logs, err := s.Environment.Readlog(int64(cfg.LogBytes)); err == nil {
+		if len(logs) > cfg.LogLines {
+			logs = logs[len(logs)-cfg.LogLines:]
+		}
+
+		r.Logs = logs
+	}
+
+	if err := os.MkdirAll(s.crashReportPath(), 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	b, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := atomicfile.WriteFile(filepath.Join(s.crashReportPath(), r.Id+".json"), b, 0600); err != nil {
+		return nil, err
+	}
+
+	if err := s.pruneCrashReports(cfg.MaxReports); err != nil {
+		zap.S().Warnw("failed to remove old crash reports for server", zap.String("server", s.Uuid), zap.Error(err))
+	}
+
+	return r, nil
+}
+
+// Returns the identifiers for all of the crash reports stored for the server, with the
+// most recent report first.
+func (s *Server) CrashReports() ([]string, error) {
+	files, err := ioutil.ReadDir(s.crashReportPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		id := strings.TrimSuffix(f.Name(), ".json")
+
+		if f.IsDir() || !crashReportIdRegex.MatchString(id) {
+			continue
+		}
+
+		ids = append(ids, id)
+	}
+
+	// The identifiers are generated from the time of the crash, so sorting them in reverse
+	// will return the newest reports first.
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	return ids, nil
+}
+
+// Returns the raw contents of a crash report for the server. If the identifier provided
+// is not in the expected format, or no report exists, an os.ErrNotExist error is returned.
+func (s *Server) ReadCrashReport(id string) ([]byte, error) {
+	if !crashReportIdRegex.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+
+	return ioutil.ReadFile(filepath.Join(s.crashReportPath(), id+".json"))
+}
+
+// Removes the oldest crash reports for the server until no more than the given number
+// of reports remain.
+func (s *Server) pruneCrashReports(max int) error {
+	ids, err := s.CrashReports()
+	if err != nil {
+		return err
+	}
+
+	if max < 0 || len(ids) <= max {
+		return nil
+	}
+
+	for _, id := range ids[max:] {
+		if err := os.Remove(filepath.Join(s.crashReportPath(), id+".json")); err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"io"
+	"sync"
+	"time"
+)
+
+// Defines all of the Docker engine API calls that are made by the daemon. This is satisfied
+// by the official Docker client, but allows a different implementation to be injected, such
+// as the in-memory engine provided by the dockertest package.
+type DockerClient interface {
+	ContainerAttach(ctx context.Context, container string, options types.ContainerAttachOptions) (types.HijackedResponse, error)
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, containerName string) (container.ContainerCreateCreatedBody, error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerKill(ctx context.Context, containerID, signal string) error
+	ContainerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error)
+	ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error
+	ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error
+	ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error)
+	ContainerStop(ctx context.Context, containerID string, timeout *time.Duration) error
+	ContainerUpdate(ctx context.Context, containerID string, updateConfig container.UpdateConfig) (container.ContainerUpdateOKBody, error)
+	ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error)
+	ImagePull(ctx context.Context, ref string, options types.ImagePullOptions) (io.ReadCloser, error)
+	NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error)
+	NetworkInspect(ctx context.Context, networkID string, options types.NetworkInspectOptions) (types.NetworkResource, error)
+}
+
+// Ensure that the official Docker client always satisfies the interface.
+var _ DockerClient = (*client.Client)(nil)
+
+var _dockerClient DockerClient
+var _dockerClientMutex sync.Mutex
+
+// Sets the Docker client that should be used by all of the Docker environments and
+// installation processes created after this is called.
+func SetDockerClient(c DockerClient) {
+	_dockerClientMutex.Lock()
+	defer _dockerClientMutex.Unlock()
+
+	_dockerClient = c
+}
+
+// Returns the Docker client used by the daemon. If no client has been set using
+// SetDockerClient, a new client is created using the environment configuration the
+// first time this is called.
+func GetDockerClient() (DockerClient, error) {
+	_dockerClientMutex.Lock()
+	defer _dockerClientMutex.Unlock()
+
+	if _dockerClient == nil {
+		cli, err := client.NewClientWithOpts(client.FromEnv)
+		if err != nil {
+			return nil, err
+		}
+
+		_dockerClient = cli
+	}
+
+	return _dockerClient, nil
+}
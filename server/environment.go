@@ -1,57 +1,125 @@
 package server
 
 import (
+	"context"
 	"os"
+	"sort"
+	"sync"
+	"time"
 )
 
+// Defines a function that is used to create a new environment instance for a server.
+type EnvironmentFactory func(server *Server) (Environment, error)
+
+var environments = make(map[string]EnvironmentFactory)
+var environmentsMutex sync.RWMutex
+
+// Registers an environment driver so that it can be selected by servers using the given
+// name. Registering a driver with a name that is already in use will replace the existing
+// driver. This should be called from the init function of the file defining the driver.
+func RegisterEnvironment(name string, factory EnvironmentFactory) {
+	environmentsMutex.Lock()
+	defer environmentsMutex.Unlock()
+
+	environments[name] = factory
+}
+
+// Returns the names of all of the environment drivers that have been registered, sorted
+// alphabetically.
+func RegisteredEnvironments() []string {
+	environmentsMutex.RLock()
+	defer environmentsMutex.RUnlock()
+
+	names := make([]string, 0, len(environments))
+	for name := range environments {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Creates a new environment for the server using the driver registered with the given
+// name. If there is no driver registered with that name an error is returned.
+func NewEnvironment(name string, server *Server) (Environment, error) {
+	environmentsMutex.RLock()
+	factory, ok := environments[name]
+	environmentsMutex.RUnlock()
+
+	if !ok {
+		return nil, &unknownEnvironment{name: name}
+	}
+
+	return factory(server)
+}
+
 // Defines the basic interface that all environments need to implement so that
 // a server can be properly controlled.
+//
+// Functions accepting a context should abort as soon as possible once it is cancelled.
+// Long running processes started by an environment, such as the console attachment and
+// resource polling, are not tied to the context of the function that started them.
 type Environment interface {
 	// Returns the name of the environment.
 	Type() string
 
 	// Determines if the environment is currently active and running a server process
 	// for this specific server instance.
-	IsRunning() (bool, error)
+	IsRunning(ctx context.Context) (bool, error)
 
 	// Performs an update of server resource limits without actually stopping the server
 	// process. This only executes if the environment supports it, otherwise it is
 	// a no-op.
-	InSituUpdate() error
+	InSituUpdate(ctx context.Context) error
 
 	// Runs before the environment is started. If an error is returned starting will
 	// not occur, otherwise proceeds as normal.
-	OnBeforeStart() error
+	OnBeforeStart(ctx context.Context) error
 
 	// Starts a server instance. If the server instance is not in a state where it
 	// can be started an error should be returned.
-	Start() error
+	Start(ctx context.Context) error
 
 	// Stops a server instance. If the server is already stopped an error should
 	// not be returned.
-	Stop() error
+	Stop(ctx context.Context) error
+
+	// Restarts a server instance by stopping it using the configured stop method, waiting
+	// for the process to exit, and then starting it back up. If the process does not stop
+	// in a timely manner it should be forcibly terminated before being started again.
+	Restart(ctx context.Context) error
+
+	// Stops a server instance and then blocks until the process has completely exited and
+	// the server is offline. If the process is still running once the timeout has passed
+	// it is forcibly terminated when terminate is true, otherwise an error is returned.
+	WaitForStop(ctx context.Context, timeout time.Duration, terminate bool) error
 
 	// Determines if the server instance exists. For example, in a docker environment
 	// this should confirm that the container is created and in a bootable state. In
 	// a basic CLI environment this can probably just return true right away.
-	Exists() (bool, error)
+	Exists(ctx context.Context) (bool, error)
 
 	// Terminates a running server instance using the provided signal. If the server
 	// is not running no error should be returned.
-	Terminate(signal os.Signal) error
+	Terminate(ctx context.Context, signal os.Signal) error
 
 	// Destroys the environment removing any containers that were created (in Docker
 	// environments at least).
-	Destroy() error
+	Destroy(ctx context.Context) error
+
+	// Returns a summary of the environment the server process is running in. This is used
+	// for diagnostic purposes, such as when generating crash reports.
+	Summary(ctx context.Context) (map[string]interface{}, error)
 
 	// Returns the exit state of the process. The first result is the exit code, the second
 	// determines if the process was killed by the system OOM killer.
-	ExitState() (uint32, bool, error)
+	ExitState(ctx context.Context) (uint32, bool, error)
 
 	// Creates the necessary environment for running the server process. For example,
 	// in the Docker environment create will create a new container instance for the
 	// server.
-	Create() error
+	Create(ctx context.Context) error
 
 	// Attaches to the server console environment and allows piping the output to a
 	// websocket or other internal tool to monitor output. Also allows you to later
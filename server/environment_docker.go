@@ -20,6 +20,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,7 +29,7 @@ type DockerEnvironment struct {
 	Server *Server
 
 	// The Docker client being used for this instance.
-	Client *client.Client
+	Client DockerClient
 
 	// Tracks if we are currently attached to the server container. This allows us to attach
 	// once and then just use that attachment to stream logs out of the server and also stream
@@ -40,23 +41,31 @@ type DockerEnvironment struct {
 	stream types.HijackedResponse
 
 	// Holds the stats stream used by the polling commands so that we can easily close
-	// it out.
-	stats io.ReadCloser
+	// it out. The stream is replaced each time the container is attached to, so it must
+	// only be accessed while holding the mutex.
+	stats      io.ReadCloser
+	statsMutex sync.Mutex
+
+	// Tracks the process reading from the attached container stream so that we can block
+	// until the daemon has completely detached from a container that has stopped.
+	attachment sync.WaitGroup
 }
 
-// Creates a new base Docker environment. A server must still be attached to it.
-func NewDockerEnvironment(server *Server) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+func init() {
+	RegisterEnvironment("docker", NewDockerEnvironment)
+}
+
+// Creates a new Docker environment for the given server.
+func NewDockerEnvironment(server *Server) (Environment, error) {
+	cli, err := GetDockerClient()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	server.Environment = &DockerEnvironment{
+	return &DockerEnvironment{
 		Server: server,
 		Client: cli,
-	}
-
-	return nil
+	}, nil
 }
 
 // Ensure that the Docker environment is always implementing all of the methods
@@ -69,8 +78,8 @@ func (d *DockerEnvironment) Type() string {
 }
 
 // Determines if the container exists in this environment.
-func (d *DockerEnvironment) Exists() (bool, error) {
-	_, err := d.Client.ContainerInspect(context.Background(), d.Server.Uuid)
+func (d *DockerEnvironment) Exists(ctx context.Context) (bool, error) {
+	_, err := d.Client.ContainerInspect(ctx, d.Server.Uuid)
 
 	if err != nil {
 		// If this error is because the container instance wasn't found via Docker we
@@ -93,9 +102,7 @@ func (d *DockerEnvironment) Exists() (bool, error) {
 // API.
 //
 // @see docker/client/errors.go
-func (d *DockerEnvironment) IsRunning() (bool, error) {
-	ctx := context.Background()
-
+func (d *DockerEnvironment) IsRunning(ctx context.Context) (bool, error) {
 	c, err := d.Client.ContainerInspect(ctx, d.Server.Uuid)
 	if err != nil {
 		return false, err
@@ -107,8 +114,8 @@ func (d *DockerEnvironment) IsRunning() (bool, error) {
 // Performs an in-place update of the Docker container's resource limits without actually
 // making any changes to the operational state of the container. This allows memory, cpu,
 // and IO limitations to be adjusted on the fly for individual instances.
-func (d *DockerEnvironment) InSituUpdate() error {
-	if _, err := d.Client.ContainerInspect(context.Background(), d.Server.Uuid); err != nil {
+func (d *DockerEnvironment) InSituUpdate(ctx context.Context) error {
+	if _, err := d.Client.ContainerInspect(ctx, d.Server.Uuid); err != nil {
 		// If the container doesn't exist for some reason there really isn't anything
 		// we can do to fix that in this process (it doesn't make sense at least). In those
 		// cases just return without doing anything since we still want to save the configuration
@@ -126,7 +133,7 @@ func (d *DockerEnvironment) InSituUpdate() error {
 		Resources: d.getResourcesForServer(),
 	}
 
-	if _, err := d.Client.ContainerUpdate(context.Background(), d.Server.Uuid, u); err != nil {
+	if _, err := d.Client.ContainerUpdate(ctx, d.Server.Uuid, u); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -140,15 +147,15 @@ func (d *DockerEnvironment) InSituUpdate() error {
 // This process will also confirm that the server environment exists and is in a bootable
 // state. This ensures that unexpected container deletion while Wings is running does
 // not result in the server becoming unbootable.
-func (d *DockerEnvironment) OnBeforeStart() error {
+func (d *DockerEnvironment) OnBeforeStart(ctx context.Context) error {
 	zap.S().Infow("syncing server configuration with Panel", zap.String("server", d.Server.Uuid))
-	if err := d.Server.Sync(); err != nil {
+	if err := d.Server.Sync(ctx); err != nil {
 		return err
 	}
 
 	// Always destroy and re-create the server container to ensure that synced data from
 	// the Panel is used.
-	if err := d.Client.ContainerRemove(context.Background(), d.Server.Uuid, types.ContainerRemoveOptions{RemoveVolumes: true}); err != nil {
+	if err := d.Client.ContainerRemove(ctx, d.Server.Uuid, types.ContainerRemoveOptions{RemoveVolumes: true}); err != nil {
 		if !client.IsErrNotFound(err) {
 			return err
 		}
@@ -161,7 +168,7 @@ func (d *DockerEnvironment) OnBeforeStart() error {
 	// This won't actually run an installation process however, it is just here to ensure the
 	// environment gets created properly if it is missing and the server is started. We're making
 	// an assumption that all of the files will still exist at this point.
-	if err := d.Create(); err != nil {
+	if err := d.Create(ctx); err != nil {
 		return err
 	}
 
@@ -171,14 +178,14 @@ func (d *DockerEnvironment) OnBeforeStart() error {
 // Starts the server environment and begins piping output to the event listeners for the
 // console. If a container does not exist, or needs to be rebuilt that will happen in the
 // call to OnBeforeStart().
-func (d *DockerEnvironment) Start() error {
+func (d *DockerEnvironment) Start(ctx context.Context) error {
 	sawError := false
 	// If sawError is set to true there was an error somewhere in the pipeline that
 	// got passed up, but we also want to ensure we set the server to be offline at
 	// that point.
 	defer func() {
 		if sawError {
-			d.Server.SetState(ProcessOfflineState)
+			d.Server.setStateOrWarn(ProcessOfflineState, "failed to start server process")
 		}
 	}()
 
@@ -192,19 +199,28 @@ func (d *DockerEnvironment) Start() error {
 		return &suspendedError{}
 	}
 
-	c, err := d.Client.ContainerInspect(context.Background(), d.Server.Uuid)
+	c, err := d.Client.ContainerInspect(ctx, d.Server.Uuid)
 	if err != nil && !client.IsErrNotFound(err) {
 		return errors.WithStack(err)
 	}
 
+	// The container is created in OnBeforeStart if it does not exist yet, in which case
+	// there is nothing returned from the inspection to check.
+	exists := err == nil
+
 	// No reason to try starting a container that is already running.
-	if c.State.Running {
-		d.Server.SetState(ProcessRunningState)
+	if exists && c.State.Running {
+		if err := d.Server.SetState(ProcessRunningState, "attached to running server process"); err != nil {
+			return err
+		}
 
 		return d.Attach()
 	}
 
-	d.Server.SetState(ProcessStartingState)
+	if err := d.Server.SetState(ProcessStartingState, "start requested"); err != nil {
+		return err
+	}
+
 	// Set this to true for now, we will set it to false once we reach the
 	// end of this chain.
 	sawError = true
@@ -212,16 +228,18 @@ func (d *DockerEnvironment) Start() error {
 	// Run the before start function and wait for it to finish. This will validate that the container
 	// exists on the system, and rebuild the container if that is required for server booting to
 	// occur.
-	if err := d.OnBeforeStart(); err != nil {
+	if err := d.OnBeforeStart(ctx); err != nil {
 		return errors.WithStack(err)
 	}
 
 	// Truncate the log file so we don't end up outputting a bunch of useless log information
 	// to the websocket and whatnot. Check first that the path and file exist before trying
 	// to truncate them.
-	if _, err := os.Stat(c.LogPath); err == nil {
-		if err := os.Truncate(c.LogPath, 0); err != nil {
-			return errors.WithStack(err)
+	if exists {
+		if _, err := os.Stat(c.LogPath); err == nil {
+			if err := os.Truncate(c.LogPath, 0); err != nil {
+				return errors.WithStack(err)
+			}
 		}
 	}
 
@@ -239,7 +257,7 @@ func (d *DockerEnvironment) Start() error {
 	}
 
 	opts := types.ContainerStartOptions{}
-	if err := d.Client.ContainerStart(context.Background(), d.Server.Uuid, opts); err != nil {
+	if err := d.Client.ContainerStart(ctx, d.Server.Uuid, opts); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -251,26 +269,103 @@ func (d *DockerEnvironment) Start() error {
 
 // Stops the container that the server is running in. This will allow up to 10
 // seconds to pass before a failure occurs.
-func (d *DockerEnvironment) Stop() error {
-	stop := d.Server.processConfiguration.Stop
+func (d *DockerEnvironment) Stop(ctx context.Context) error {
+	stop := d.Server.ProcessConfiguration().Stop
 	if stop.Type == api.ProcessStopSignal {
-		return d.Terminate(os.Kill)
+		return d.Terminate(ctx, os.Kill)
 	}
 
-	d.Server.SetState(ProcessStoppingState)
+	d.Server.setStateOrWarn(ProcessStoppingState, "stop requested")
 	if stop.Type == api.ProcessStopCommand {
 		return d.SendCommand(stop.Value)
 	}
 
 	t := time.Second * 10
 
-	return d.Client.ContainerStop(context.Background(), d.Server.Uuid, &t)
+	return d.Client.ContainerStop(ctx, d.Server.Uuid, &t)
 }
 
-// Forcefully terminates the container using the signal passed through.
-func (d *DockerEnvironment) Terminate(signal os.Signal) error {
-	ctx := context.Background()
+// Restarts the server process by sending the configured stop action for the egg, waiting
+// for the container to exit, and then running through the normal boot process. If the
+// container does not stop within the configured timeout it is forcibly killed.
+func (d *DockerEnvironment) Restart(ctx context.Context) error {
+	if err := d.WaitForStop(ctx, time.Second*time.Duration(config.Get().System.StopTimeout), true); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return d.Start(ctx)
+}
+
+// Stops the server process and then blocks until the container has exited and the daemon
+// has detached from it, at which point the server will be in the offline state. If the
+// container is still running once the timeout has passed it will be killed if terminate
+// is true, otherwise a context.DeadlineExceeded error is returned.
+func (d *DockerEnvironment) WaitForStop(ctx context.Context, timeout time.Duration, terminate bool) error {
+	running, err := d.IsRunning(ctx)
+	if err != nil && !client.IsErrNotFound(err) {
+		return errors.WithStack(err)
+	}
+
+	if running {
+		if err := d.Stop(ctx); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := d.waitForContainerExit(ctx, timeout); err != nil {
+			// Only escalate if it was our own timeout that expired, a cancelled parent
+			// context means the caller is no longer interested in the result.
+			if err != context.DeadlineExceeded || !terminate || ctx.Err() != nil {
+				return errors.WithStack(err)
+			}
+
+			// The process ignored the stop action (or is taking too long to act on it),
+			// so escalate to a SIGKILL and wait for the container to actually exit.
+			zap.S().Infow("server did not stop within the allotted time; terminating process", zap.String("server", d.Server.Uuid))
+			if err := d.Terminate(ctx, os.Kill); err != nil {
+				return errors.WithStack(err)
+			}
+
+			if err := d.waitForContainerExit(ctx, 0); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	// Block until the attached stream has been closed out and the server has been marked
+	// as offline, otherwise that process could change the server state after we've already
+	// returned to the caller.
+	d.attachment.Wait()
+
+	return nil
+}
+
+// Uses the Docker wait API to block until the container is no longer running. If a timeout
+// greater than zero is provided and the container is still running once it has passed, a
+// context.DeadlineExceeded error is returned.
+func (d *DockerEnvironment) waitForContainerExit(ctx context.Context, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ok, errChan := d.Client.ContainerWait(ctx, d.Server.Uuid, container.WaitConditionNotRunning)
+	select {
+	case <-ok:
+		return nil
+	case err := <-errChan:
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Forcefully terminates the container using the signal passed through.
+func (d *DockerEnvironment) Terminate(ctx context.Context, signal os.Signal) error {
 	c, err := d.Client.ContainerInspect(ctx, d.Server.Uuid)
 	if err != nil {
 		return errors.WithStack(err)
@@ -280,7 +375,7 @@ func (d *DockerEnvironment) Terminate(signal os.Signal) error {
 		return nil
 	}
 
-	d.Server.SetState(ProcessStoppingState)
+	d.Server.setStateOrWarn(ProcessStoppingState, "process terminated")
 
 	return d.Client.ContainerKill(
 		ctx, d.Server.Uuid, strings.TrimSuffix(strings.TrimPrefix(signal.String(), "signal "), "ed"),
@@ -289,11 +384,10 @@ func (d *DockerEnvironment) Terminate(signal os.Signal) error {
 
 // Remove the Docker container from the machine. If the container is currently running
 // it will be forcibly stopped by Docker.
-func (d *DockerEnvironment) Destroy() error {
-	ctx := context.Background()
-
-	// Avoid crash detection firing off.
-	d.Server.SetState(ProcessStoppingState)
+func (d *DockerEnvironment) Destroy(ctx context.Context) error {
+	// Moving the server directly into the offline state for this reason ensures that crash
+	// detection is not triggered when the container is forcibly stopped.
+	d.Server.setStateOrWarn(ProcessOfflineState, "server environment destroyed")
 
 	return d.Client.ContainerRemove(ctx, d.Server.Uuid, types.ContainerRemoveOptions{
 		RemoveVolumes: true,
@@ -302,10 +396,45 @@ func (d *DockerEnvironment) Destroy() error {
 	})
 }
 
+// Returns a summary of the container state and configuration for the server.
+func (d *DockerEnvironment) Summary(ctx context.Context) (map[string]interface{}, error) {
+	c, err := d.Client.ContainerInspect(ctx, d.Server.Uuid)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	out := map[string]interface{}{
+		"id":      c.ID,
+		"created": c.Created,
+	}
+
+	if c.Config != nil {
+		out["image"] = c.Config.Image
+	}
+
+	if c.State != nil {
+		out["status"] = c.State.Status
+		out["exit_code"] = c.State.ExitCode
+		out["oom_killed"] = c.State.OOMKilled
+		out["error"] = c.State.Error
+		out["started_at"] = c.State.StartedAt
+		out["finished_at"] = c.State.FinishedAt
+	}
+
+	if c.HostConfig != nil {
+		out["memory_limit"] = c.HostConfig.Memory
+		out["memory_swap"] = c.HostConfig.MemorySwap
+		out["cpu_quota"] = c.HostConfig.CPUQuota
+		out["oom_kill_disable"] = c.HostConfig.OomKillDisable
+	}
+
+	return out, nil
+}
+
 // Determine the container exit state and return the exit code and wether or not
 // the container was killed by the OOM killer.
-func (d *DockerEnvironment) ExitState() (uint32, bool, error) {
-	c, err := d.Client.ContainerInspect(context.Background(), d.Server.Uuid)
+func (d *DockerEnvironment) ExitState(ctx context.Context) (uint32, bool, error) {
+	c, err := d.Client.ContainerInspect(ctx, d.Server.Uuid)
 	if err != nil {
 		return 0, false, errors.WithStack(err)
 	}
@@ -345,21 +474,25 @@ func (d *DockerEnvironment) Attach() error {
 	}
 
 	d.attached = true
+	d.attachment.Add(1)
 	go func() {
 		if err := d.EnableResourcePolling(); err != nil {
 			zap.S().Warnw("failed to enabled resource polling on server", zap.String("server", d.Server.Uuid), zap.Error(errors.WithStack(err)))
 		}
 	}()
 
-	go func() {
-		defer d.stream.Close()
-		defer func() {
-			d.Server.SetState(ProcessOfflineState)
-			d.attached = false
-		}()
+	// The stream is passed in since the server may be attached to again, replacing it, as
+	// soon as it has been marked as offline. Everything else is done before changing the
+	// state for the same reason, since that may start the crash handler.
+	go func(stream types.HijackedResponse) {
+		defer d.attachment.Done()
 
-		io.Copy(console, d.stream.Reader)
-	}()
+		io.Copy(console, stream.Reader)
+
+		stream.Close()
+		d.attached = false
+		d.Server.setStateOrWarn(ProcessOfflineState, StateReasonProcessExited)
+	}(d.stream)
 
 	return nil
 }
@@ -368,7 +501,7 @@ func (d *DockerEnvironment) Attach() error {
 // happens in the split seconds before the code moves from 'Starting' to 'Attaching'
 // on the process.
 func (d *DockerEnvironment) FollowConsoleOutput() error {
-	if exists, err := d.Exists(); !exists {
+	if exists, err := d.Exists(context.Background()); !exists {
 		if err != nil {
 			return errors.WithStack(err)
 		}
@@ -376,6 +509,11 @@ func (d *DockerEnvironment) FollowConsoleOutput() error {
 		return errors.New(fmt.Sprintf("no such container: %s", d.Server.Uuid))
 	}
 
+	// Output from before this point is not followed, so fill the console history with it
+	// if the daemon was restarted while the container was running.
+	d.Server.seedConsoleHistory()
+	d.Server.throttler.Reset()
+
 	ctx := context.Background()
 	opts := types.ContainerLogsOptions{
 		ShowStderr: true,
@@ -391,7 +529,9 @@ func (d *DockerEnvironment) FollowConsoleOutput() error {
 
 		s := bufio.NewScanner(r)
 		for s.Scan() {
-			d.Server.Events().Publish(ConsoleOutputEvent, s.Text())
+			if d.Server.throttleConsoleOutput(s.Bytes()) {
+				d.Server.PublishConsoleOutput(s.Text())
+			}
 		}
 
 		if err := s.Err(); err != nil {
@@ -406,7 +546,7 @@ func (d *DockerEnvironment) FollowConsoleOutput() error {
 // information, instead just sit there with an async process that lets Docker stream all of this data
 // to us automatically.
 func (d *DockerEnvironment) EnableResourcePolling() error {
-	if d.Server.State == ProcessOfflineState {
+	if d.Server.GetState() == ProcessOfflineState {
 		return errors.New("cannot enable resource polling on a server that is not running")
 	}
 
@@ -416,10 +556,21 @@ func (d *DockerEnvironment) EnableResourcePolling() error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
+
+	d.statsMutex.Lock()
 	d.stats = stats.Body
+	d.statsMutex.Unlock()
+
+	dec := json.NewDecoder(stats.Body)
+	go func(s *Server, body io.ReadCloser) {
+		// Only this stream is closed when polling stops, since the container may have
+		// been attached to again with a new stream by then.
+		defer func() {
+			d.statsMutex.Lock()
+			d.closeStats(body)
+			d.statsMutex.Unlock()
+		}()
 
-	dec := json.NewDecoder(d.stats)
-	go func(s *Server) {
 		for {
 			var v *types.StatsJSON
 
@@ -428,50 +579,65 @@ func (d *DockerEnvironment) EnableResourcePolling() error {
 					zap.S().Warnw("encountered error processing server stats; stopping collection", zap.Error(err))
 				}
 
-				d.DisableResourcePolling()
 				return
 			}
 
 			// Disable collection if the server is in an offline state and this process is
 			// still running.
-			if s.State == ProcessOfflineState {
-				d.DisableResourcePolling()
+			if s.GetState() == ProcessOfflineState {
 				return
 			}
 
-			s.Resources.CpuAbsolute = s.Resources.CalculateAbsoluteCpu(&v.PreCPUStats, &v.CPUStats)
-			s.Resources.Memory = v.MemoryStats.Usage
-			s.Resources.MemoryLimit = v.MemoryStats.Limit
-
 			// Why you ask? This already has the logic for caching disk space in use and then
 			// also handles pushing that value to the resources object automatically.
 			s.Filesystem.HasSpaceAvailable()
 
-			for _, nw := range v.Networks {
-				s.Resources.Network.RxBytes += nw.RxBytes
-				s.Resources.Network.TxBytes += nw.TxBytes
-			}
+			usage := s.UpdateResources(func(r *ResourceUsage) {
+				r.CpuAbsolute = r.CalculateAbsoluteCpu(&v.PreCPUStats, &v.CPUStats)
+				r.Memory = v.MemoryStats.Usage
+				r.MemoryLimit = v.MemoryStats.Limit
 
-			b, _ := json.Marshal(s.Resources)
+				for _, nw := range v.Networks {
+					r.Network.RxBytes += nw.RxBytes
+					r.Network.TxBytes += nw.TxBytes
+				}
+			})
+
+			b, _ := json.Marshal(usage)
 			s.Events().Publish(StatsEvent, string(b))
 		}
-	}(d.Server)
+	}(d.Server, stats.Body)
 
 	return nil
 }
 
 // Closes the stats stream for a server process.
 func (d *DockerEnvironment) DisableResourcePolling() error {
-	if d.stats == nil {
+	d.statsMutex.Lock()
+	defer d.statsMutex.Unlock()
+
+	return d.closeStats(d.stats)
+}
+
+// Closes a stats stream, and records the final resource usage for the server if it is the
+// stream currently being polled. The stats mutex must be held when calling this.
+func (d *DockerEnvironment) closeStats(stats io.ReadCloser) error {
+	if stats == nil {
 		return nil
 	}
 
-	err := d.stats.Close()
+	err := stats.Close()
+
+	if stats == d.stats {
+		d.stats = nil
 
-	d.Server.Resources.CpuAbsolute = 0
-	d.Server.Resources.Memory = 0
-	d.Server.Resources.Network.TxBytes = 0
-	d.Server.Resources.Network.RxBytes = 0
+		d.Server.finishResources(func(r *ResourceUsage) {
+			r.CpuAbsolute = 0
+			r.Memory = 0
+			r.Network.TxBytes = 0
+			r.Network.RxBytes = 0
+		})
+	}
 
 	return errors.WithStack(err)
 }
@@ -479,8 +645,8 @@ func (d *DockerEnvironment) DisableResourcePolling() error {
 // Pulls the image from Docker.
 //
 // @todo handle authorization & local images
-func (d *DockerEnvironment) ensureImageExists(c *client.Client) error {
-	out, err := c.ImagePull(context.Background(), d.Server.Container.Image, types.ImagePullOptions{All: false})
+func (d *DockerEnvironment) ensureImageExists(ctx context.Context) error {
+	out, err := d.Client.ImagePull(ctx, d.Server.Container.Image, types.ImagePullOptions{All: false})
 	if err != nil {
 		return err
 	}
@@ -506,13 +672,7 @@ func (d *DockerEnvironment) ensureImageExists(c *client.Client) error {
 // available for it. If the container already exists it will be returned.
 //
 // @todo pull the image being requested if it doesn't exist currently.
-func (d *DockerEnvironment) Create() error {
-	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
+func (d *DockerEnvironment) Create(ctx context.Context) error {
 	// Ensure the data directory exists before getting too far through this process.
 	if err := d.Server.Filesystem.EnsureDataDirectory(); err != nil {
 		return errors.WithStack(err)
@@ -521,14 +681,14 @@ func (d *DockerEnvironment) Create() error {
 	// If the container already exists don't hit the user with an error, just return
 	// the current information about it which is what we would do when creating the
 	// container anyways.
-	if _, err := cli.ContainerInspect(ctx, d.Server.Uuid); err == nil {
+	if _, err := d.Client.ContainerInspect(ctx, d.Server.Uuid); err == nil {
 		return nil
 	} else if !client.IsErrNotFound(err) {
 		return errors.WithStack(err)
 	}
 
 	// Try to pull the requested image before creating the container.
-	if err := d.ensureImageExists(cli); err != nil {
+	if err := d.ensureImageExists(ctx); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -611,7 +771,7 @@ func (d *DockerEnvironment) Create() error {
 	// 	}
 	// }
 
-	if _, err := cli.ContainerCreate(ctx, conf, hostConf, nil, d.Server.Uuid); err != nil {
+	if _, err := d.Client.ContainerCreate(ctx, conf, hostConf, nil, d.Server.Uuid); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -0,0 +1,214 @@
+package server_test
+
+import (
+	"context"
+	"github.com/pterodactyl/wings/dockertest"
+	"github.com/pterodactyl/wings/paneltest"
+	"github.com/pterodactyl/wings/server"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testServerUuid = "0f2d5cf6-7bd4-4b0e-9a4c-8f4c5e1a2b3c"
+
+const testServerImage = "quay.io/pterodactyl/core:java"
+
+// Starts a fake Docker engine and uses it as the Docker client for the duration of the
+// test. The engine echoes back commands written to a container, and stops the container
+// when it receives "stop".
+func newEngine(t *testing.T) (*dockertest.Engine, func()) {
+	t.Helper()
+
+	e, err := dockertest.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e.AddImage(testServerImage)
+	e.OnCommand = func(e *dockertest.Engine, id string, command string) {
+		e.Output(id, "got "+command)
+		if command == "stop" {
+			e.Exit(id, 0, false)
+		}
+	}
+
+	server.SetDockerClient(e)
+
+	return e, func() {
+		server.SetDockerClient(nil)
+		e.Close()
+	}
+}
+
+// Creates a server using the Docker environment. The server is considered started once
+// it outputs "ready", and is stopped using the "stop" command.
+func newDockerServer(t *testing.T, crashDetection map[string]interface{}) (*server.Server, *paneltest.Panel, func()) {
+	t.Helper()
+
+	settings := map[string]interface{}{
+		"invocation": "java -jar server.jar",
+		"container":  map[string]interface{}{"image": testServerImage},
+	}
+
+	if crashDetection != nil {
+		settings["crash_detection"] = crashDetection
+	}
+
+	return server.NewTestServer(t, "docker", settings, map[string]interface{}{
+		"startup": map[string]interface{}{"done": "ready"},
+		"stop":    map[string]interface{}{"type": "command", "value": "stop"},
+	})
+}
+
+func TestDockerEnvironmentStartAndStop(t *testing.T) {
+	e, closeEngine := newEngine(t)
+	defer closeEngine()
+
+	s, _, cleanup := newDockerServer(t, nil)
+	defer cleanup()
+
+	sub := s.Events().Subscribe(server.StatusEvent, server.ConsoleOutputEvent)
+	defer sub.Close()
+
+	ctx := context.Background()
+	if err := s.Environment.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	server.WaitForState(t, sub, server.ProcessStartingState)
+
+	c, ok := e.Container(testServerUuid)
+	if !ok || !c.Running {
+		t.Fatal("expected server container to be created and running")
+	}
+
+	if c.Config.Image != testServerImage {
+		t.Fatalf("expected container to use image %s, got %s", testServerImage, c.Config.Image)
+	}
+
+	e.Output(testServerUuid, "ready")
+	server.WaitForState(t, sub, server.ProcessRunningState)
+
+	if err := s.Environment.SendCommand("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.WaitForOutput(t, sub, "got hello")
+
+	if err := s.Environment.WaitForStop(ctx, time.Second*10, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if running, err := s.Environment.IsRunning(ctx); err != nil || running {
+		t.Fatalf("expected container to have stopped: %v", err)
+	}
+
+	c, _ = e.Container(testServerUuid)
+	if len(c.Commands) != 2 || c.Commands[0] != "hello" || c.Commands[1] != "stop" {
+		t.Fatalf("unexpected commands sent to container: %v", c.Commands)
+	}
+
+	if s.GetState() != server.ProcessOfflineState {
+		t.Fatalf("expected server to be offline, got %s", s.GetState())
+	}
+
+	lines, err := s.Environment.Readlog(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lines) != 3 || strings.TrimSpace(lines[0]) != "ready" || strings.TrimSpace(lines[2]) != "got stop" {
+		t.Fatalf("unexpected log contents: %v", lines)
+	}
+}
+
+func TestDockerEnvironmentRestartsCrashedServer(t *testing.T) {
+	e, closeEngine := newEngine(t)
+	defer closeEngine()
+
+	s, _, cleanup := newDockerServer(t, map[string]interface{}{
+		"enabled":      true,
+		"max_restarts": 1,
+	})
+	defer cleanup()
+
+	// Zero values sent by the Panel do not replace the defaults, so restart the server
+	// immediately by setting this directly.
+	s.CrashDetection.Backoff = 0
+
+	sub := s.Events().Subscribe(server.StatusEvent)
+	defer sub.Close()
+
+	ctx := context.Background()
+	if err := s.Environment.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	e.Output(testServerUuid, "ready")
+	server.WaitForState(t, sub, server.ProcessRunningState)
+
+	e.Exit(testServerUuid, 1, false)
+	server.WaitForState(t, sub, server.ProcessOfflineState)
+
+	// The crash handler starts the server again once the backoff has passed.
+	server.WaitForState(t, sub, server.ProcessStartingState)
+
+	if len(s.CrashDetection.History) != 1 {
+		t.Fatalf("expected one crash to be recorded, got %d", len(s.CrashDetection.History))
+	}
+
+	// The power lock is held by the crash handler until the server has been started.
+	if err := s.AcquirePowerLock(true); err != nil {
+		t.Fatal(err)
+	}
+	s.ReleasePowerLock()
+
+	c, ok := e.Container(testServerUuid)
+	if !ok || !c.Running {
+		t.Fatal("expected server container to be running again")
+	}
+
+	e.Output(testServerUuid, "ready")
+	server.WaitForState(t, sub, server.ProcessRunningState)
+
+	if err := s.Environment.WaitForStop(ctx, time.Second*10, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDockerEnvironmentDoesNotRestartStoppedServer(t *testing.T) {
+	e, closeEngine := newEngine(t)
+	defer closeEngine()
+
+	s, _, cleanup := newDockerServer(t, map[string]interface{}{"enabled": true})
+	defer cleanup()
+
+	s.CrashDetection.Backoff = 0
+
+	sub := s.Events().Subscribe(server.StatusEvent)
+	defer sub.Close()
+
+	ctx := context.Background()
+	if err := s.Environment.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	e.Output(testServerUuid, "ready")
+	server.WaitForState(t, sub, server.ProcessRunningState)
+
+	if err := s.Environment.WaitForStop(ctx, time.Second*10, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the crash handler a chance to run, should it have been triggered.
+	time.Sleep(time.Millisecond * 100)
+
+	if len(s.CrashDetection.History) != 0 {
+		t.Fatal("expected stopping the server to not be treated as a crash")
+	}
+
+	if c, _ := e.Container(testServerUuid); c.Running {
+		t.Fatal("expected server container to remain stopped")
+	}
+}
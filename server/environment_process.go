@@ -0,0 +1,645 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Returns the directory that the console output and process IDs for servers using the
+// process environment are written to. This is kept within the root directory of the server
+// store so that it does not depend on the working directory of the daemon.
+func processDirectory() string {
+	return filepath.Join(config.Get().System.Store.Root, "processes")
+}
+
+// Defines an environment that runs the server's invocation as a plain child process of the
+// daemon from within the server's data directory, without using Docker. The process is run
+// as the same user as the daemon and has no resource limits applied to it, so this should
+// only be used on development hosts and in CI environments.
+type ProcessEnvironment struct {
+	Server *Server
+
+	mutex sync.Mutex
+
+	// The running server process, this will be nil if the process has never been started.
+	cmd *exec.Cmd
+
+	// The stdin pipe for the running process that commands are written to.
+	stdin io.WriteCloser
+
+	// Closed once the running process has exited and all of its output has been handled.
+	done chan struct{}
+
+	running   bool
+	startedAt time.Time
+	exitCode  uint32
+
+	// Closed to stop the resource polling process for the server.
+	polling chan struct{}
+}
+
+func init() {
+	RegisterEnvironment("process", NewProcessEnvironment)
+}
+
+// Creates a new process environment for the given server.
+func NewProcessEnvironment(server *Server) (Environment, error) {
+	return &ProcessEnvironment{Server: server}, nil
+}
+
+// Ensure that the process environment is always implementing all of the methods
+// from the base environment interface.
+var _ Environment = (*ProcessEnvironment)(nil)
+
+// Returns the name of the environment.
+func (p *ProcessEnvironment) Type() string {
+	return "process"
+}
+
+// Determines if the server process is currently running.
+func (p *ProcessEnvironment) IsRunning(ctx context.Context) (bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.running, nil
+}
+
+// Resource limits are not applied to processes, so there is nothing to update.
+func (p *ProcessEnvironment) InSituUpdate(ctx context.Context) error {
+	return nil
+}
+
+// Syncs the server configuration with the Panel and ensures that the data directory for
+// the server exists before the process is started.
+func (p *ProcessEnvironment) OnBeforeStart(ctx context.Context) error {
+	zap.S().Infow("syncing server configuration with Panel", zap.String("server", p.Server.Uuid))
+	if err := p.Server.Sync(ctx); err != nil {
+		return err
+	}
+
+	return p.Create(ctx)
+}
+
+// Starts the server process and begins piping the output to the event listeners for the
+// console and to the log file for the server.
+func (p *ProcessEnvironment) Start(ctx context.Context) error {
+	sawError := false
+	defer func() {
+		if sawError {
+			p.Server.setStateOrWarn(ProcessOfflineState, "failed to start server process")
+		}
+	}()
+
+	if p.Server.Suspended {
+		return &suspendedError{}
+	}
+
+	if running, _ := p.IsRunning(ctx); running {
+		if err := p.Server.SetState(ProcessRunningState, "attached to running server process"); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if err := p.Server.SetState(ProcessStartingState, "start requested"); err != nil {
+		return err
+	}
+
+	sawError = true
+
+	if err := p.OnBeforeStart(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	p.Server.UpdateConfigurationFiles()
+
+	log, err := os.OpenFile(p.logPath(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	cmd := shellCommand(p.invocation())
+	cmd.Dir = p.Server.Filesystem.Path()
+	cmd.Env = append(os.Environ(), p.Server.GetEnvironmentVariables()...)
+	configureProcessAttributes(cmd)
+
+	// Combine stdout and stderr into a single stream so that the output is published to
+	// the console in the same order that the process wrote it.
+	r, w := io.Pipe()
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		log.Close()
+		r.Close()
+		w.Close()
+
+		return errors.WithStack(err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		log.Close()
+		r.Close()
+		w.Close()
+		stdin.Close()
+
+		return errors.WithStack(err)
+	}
+
+	// Record the process group so that the process can be found and killed if the daemon
+	// exits before it does.
+	if err := ioutil.WriteFile(p.pidPath(), []byte(strconv.Itoa(cmd.Process.Pid)), 0600); err != nil {
+		zap.S().Warnw("failed to write process id file for server", zap.String("server", p.Server.Uuid), zap.Error(err))
+	}
+
+	p.mutex.Lock()
+	p.cmd = cmd
+	p.stdin = stdin
+	p.done = make(chan struct{})
+	p.running = true
+	p.startedAt = time.Now()
+	p.exitCode = 0
+	p.mutex.Unlock()
+
+	p.Server.throttler.Reset()
+
+	output := make(chan struct{})
+	go func() {
+		defer close(output)
+		defer log.Close()
+
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			log.Write(append(s.Bytes(), '\n'))
+			if p.Server.throttleConsoleOutput(s.Bytes()) {
+				p.Server.PublishConsoleOutput(s.Text())
+			}
+		}
+
+		if err := s.Err(); err != nil {
+			zap.S().Warnw("error processing scanner line in console output", zap.String("server", p.Server.Uuid), zap.Error(err))
+		}
+	}()
+
+	go func(done chan struct{}) {
+		err := cmd.Wait()
+
+		// Close the writer so that the output process finishes up, and then wait for it to
+		// do so before marking the server as offline. This ensures that all of the output
+		// from the process is published before anything else happens.
+		w.Close()
+		<-output
+
+		p.mutex.Lock()
+		p.running = false
+		p.exitCode = exitCodeFromError(err)
+		p.mutex.Unlock()
+
+		if err := os.Remove(p.pidPath()); err != nil && !os.IsNotExist(err) {
+			zap.S().Warnw("failed to remove process id file for server", zap.String("server", p.Server.Uuid), zap.Error(err))
+		}
+
+		p.DisableResourcePolling()
+		p.Server.setStateOrWarn(ProcessOfflineState, StateReasonProcessExited)
+
+		close(done)
+	}(p.done)
+
+	sawError = false
+
+	if err := p.EnableResourcePolling(); err != nil {
+		zap.S().Warnw("failed to enabled resource polling on server", zap.String("server", p.Server.Uuid), zap.Error(errors.WithStack(err)))
+	}
+
+	return nil
+}
+
+// Stops the server process using the stop configuration defined for the egg.
+func (p *ProcessEnvironment) Stop(ctx context.Context) error {
+	if running, _ := p.IsRunning(ctx); !running {
+		return nil
+	}
+
+	stop := p.Server.ProcessConfiguration().Stop
+	if stop.Type == api.ProcessStopSignal {
+		return p.Terminate(ctx, os.Interrupt)
+	}
+
+	p.Server.setStateOrWarn(ProcessStoppingState, "stop requested")
+	if stop.Type == api.ProcessStopCommand {
+		return p.SendCommand(stop.Value)
+	}
+
+	return p.signal(syscall.SIGTERM)
+}
+
+// Restarts the server process by stopping it, waiting for it to exit, and then starting
+// it again. If the process does not stop within the configured timeout it is killed.
+func (p *ProcessEnvironment) Restart(ctx context.Context) error {
+	if err := p.WaitForStop(ctx, time.Second*time.Duration(config.Get().System.StopTimeout), true); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return p.Start(ctx)
+}
+
+// Stops the server process and blocks until it has exited. If the process is still running
+// once the timeout has passed it will be killed if terminate is true, otherwise a
+// context.DeadlineExceeded error is returned. If the context is cancelled this stops
+// waiting and returns the context error, leaving the process to exit on its own.
+func (p *ProcessEnvironment) WaitForStop(ctx context.Context, timeout time.Duration, terminate bool) error {
+	p.mutex.Lock()
+	running, done := p.running, p.done
+	p.mutex.Unlock()
+
+	// The process may have only just exited, so wait for the server to be marked as offline
+	// before returning, as is done when the process is stopped.
+	if !running {
+		if done != nil {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		return nil
+	}
+
+	if err := p.Stop(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+	}
+
+	if !terminate {
+		return context.DeadlineExceeded
+	}
+
+	zap.S().Infow("server did not stop within the allotted time; terminating process", zap.String("server", p.Server.Uuid))
+	if err := p.Terminate(ctx, os.Kill); err != nil {
+		return errors.WithStack(err)
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The process environment does not have anything that needs to be created ahead of
+// time, so it always exists.
+func (p *ProcessEnvironment) Exists(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// Terminates the running server process using the provided signal.
+func (p *ProcessEnvironment) Terminate(ctx context.Context, signal os.Signal) error {
+	if running, _ := p.IsRunning(ctx); !running {
+		return nil
+	}
+
+	p.Server.setStateOrWarn(ProcessStoppingState, "process terminated")
+
+	return p.signal(signal)
+}
+
+// Kills the running server process, if any, along with any process left running by a
+// previous instance of the daemon, and removes the log file for the server.
+func (p *ProcessEnvironment) Destroy(ctx context.Context) error {
+	p.Server.setStateOrWarn(ProcessOfflineState, "server environment destroyed")
+
+	p.mutex.Lock()
+	running, done := p.running, p.done
+	p.mutex.Unlock()
+
+	if running {
+		if err := p.signal(os.Kill); err != nil {
+			return errors.WithStack(err)
+		}
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if err := p.killOrphanedProcess(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(p.logPath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Returns a summary of the server process.
+func (p *ProcessEnvironment) Summary(ctx context.Context) (map[string]interface{}, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	out := map[string]interface{}{
+		"running":    p.running,
+		"exit_code":  p.exitCode,
+		"started_at": p.startedAt,
+		"invocation": p.invocation(),
+	}
+
+	if p.cmd != nil && p.cmd.Process != nil {
+		out["pid"] = p.cmd.Process.Pid
+	}
+
+	return out, nil
+}
+
+// Returns the exit code of the last server process. Processes are never killed by the
+// OOM killer since they have no memory limits applied.
+func (p *ProcessEnvironment) ExitState(ctx context.Context) (uint32, bool, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return p.exitCode, false, nil
+}
+
+// Ensures that the data directory for the server, and the directory that the process
+// logs are written to, both exist. Any server process left running by a previous instance
+// of the daemon is killed so that a second copy of it is not started.
+func (p *ProcessEnvironment) Create(ctx context.Context) error {
+	if err := p.Server.Filesystem.EnsureDataDirectory(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := os.MkdirAll(processDirectory(), 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return p.killOrphanedProcess()
+}
+
+// Server processes are run in their own process group, so they keep running if the daemon
+// exits before they do. Once that happens their output can no longer be read and commands
+// cannot be sent to them, so rather than trying to reattach, the process group recorded
+// for the server is killed.
+func (p *ProcessEnvironment) killOrphanedProcess() error {
+	p.mutex.Lock()
+	running := p.running
+	p.mutex.Unlock()
+
+	if running {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(p.pidPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.WithStack(err)
+	}
+
+	if pgid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && pgid > 0 {
+		killed, err := killOrphanedProcessGroup(pgid, p.Server.Filesystem.Path())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if killed {
+			zap.S().Warnw("killed server process left running by a previous instance of the daemon", zap.String("server", p.Server.Uuid), zap.Int("pid", pgid))
+		}
+	}
+
+	if err := os.Remove(p.pidPath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// The process output is piped to the console as soon as it is started, so there is
+// nothing to attach to.
+func (p *ProcessEnvironment) Attach() error {
+	return nil
+}
+
+// The process output is piped to the console as soon as it is started, so there is
+// nothing to follow.
+func (p *ProcessEnvironment) FollowConsoleOutput() error {
+	return nil
+}
+
+// Writes the command to the stdin of the running server process.
+func (p *ProcessEnvironment) SendCommand(c string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.running {
+		return errors.New("attempting to send command to non-running process")
+	}
+
+	_, err := p.stdin.Write([]byte(c + "\n"))
+
+	return errors.WithStack(err)
+}
+
+// Reads the log file for the server process from the end backwards until the provided
+// number of bytes is met.
+func (p *ProcessEnvironment) Readlog(len int64) ([]string, error) {
+	f, err := os.Open(p.logPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if stat, err := f.Stat(); err != nil {
+		return nil, err
+	} else if stat.Size() < len {
+		len = stat.Size()
+	}
+
+	if _, err := f.Seek(-len, io.SeekEnd); err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, len)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+
+	var out []string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		out = append(out, s.Text())
+	}
+
+	return out, s.Err()
+}
+
+// Polls the process information for the server every second and publishes the resource
+// usage to the stats event listeners.
+func (p *ProcessEnvironment) EnableResourcePolling() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.running {
+		return errors.New("cannot enable resource polling on a server that is not running")
+	}
+
+	if p.polling != nil {
+		return nil
+	}
+
+	p.polling = make(chan struct{})
+
+	go func(pid int, stop chan struct{}) {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		var previous uint64
+		last := time.Now()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-ticker.C:
+				ticks, memory, err := processGroupUsage(pid)
+				if err != nil {
+					zap.S().Debugw("failed to read resource usage for server process", zap.String("server", p.Server.Uuid), zap.Error(err))
+					continue
+				}
+
+				// The CPU ticks are measured in hundredths of a second, so the percentage of a
+				// single core that was used is the change in ticks divided by the seconds that
+				// have passed.
+				p.Server.Filesystem.HasSpaceAvailable()
+
+				elapsed := now.Sub(last).Seconds()
+				usage := p.Server.UpdateResources(func(r *ResourceUsage) {
+					if previous > 0 && ticks >= previous {
+						r.CpuAbsolute = float64(ticks-previous) / elapsed
+					}
+
+					r.Memory = memory
+					r.MemoryLimit = uint64(p.Server.Build.MemoryLimit * 1000000)
+				})
+
+				previous = ticks
+				last = now
+
+				b, _ := json.Marshal(usage)
+				p.Server.Events().Publish(StatsEvent, string(b))
+			}
+		}
+	}(p.cmd.Process.Pid, p.polling)
+
+	return nil
+}
+
+// Stops polling the process for resource usage and resets the usage values for the server.
+func (p *ProcessEnvironment) DisableResourcePolling() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.polling == nil {
+		return nil
+	}
+
+	close(p.polling)
+	p.polling = nil
+
+	p.Server.finishResources(func(r *ResourceUsage) {
+		r.CpuAbsolute = 0
+		r.Memory = 0
+	})
+
+	return nil
+}
+
+// Sends a signal to the running server process, and any processes that it has started.
+func (p *ProcessEnvironment) signal(sig os.Signal) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.running || p.cmd.Process == nil {
+		return nil
+	}
+
+	return errors.WithStack(signalProcessGroup(p.cmd.Process, sig))
+}
+
+// Returns the path to the log file for the server process.
+func (p *ProcessEnvironment) logPath() string {
+	return filepath.Join(processDirectory(), p.Server.Uuid+".log")
+}
+
+// Returns the path to the file that the process group of the running server process is
+// recorded in.
+func (p *ProcessEnvironment) pidPath() string {
+	return filepath.Join(processDirectory(), p.Server.Uuid+".pid")
+}
+
+// Returns the invocation for the server with any {{VARIABLE}} placeholders replaced with
+// the value of the matching environment variable for the server.
+func (p *ProcessEnvironment) invocation() string {
+	out := p.Server.Invocation
+
+	for _, v := range p.Server.GetEnvironmentVariables() {
+		parts := strings.SplitN(v, "=", 2)
+		out = strings.Replace(out, fmt.Sprintf("{{%s}}", parts[0]), parts[1], -1)
+	}
+
+	return out
+}
+
+// Returns the exit code for a process given the error returned when waiting on it.
+func exitCodeFromError(err error) uint32 {
+	if err == nil {
+		return 0
+	}
+
+	if e, ok := err.(*exec.ExitError); ok {
+		if status, ok := e.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return uint32(128 + int(status.Signal()))
+			}
+
+			return uint32(status.ExitStatus())
+		}
+	}
+
+	return 1
+}
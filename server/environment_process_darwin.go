@@ -0,0 +1,60 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// Returns the command used to run the server invocation through the system shell.
+func shellCommand(invocation string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", invocation)
+}
+
+// Runs the server process in its own process group so that signals can be sent to any
+// processes that it starts as well.
+func configureProcessAttributes(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Sends the signal to every process in the process group led by the given process.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
+	}
+
+	return syscall.Kill(-p.Pid, s)
+}
+
+// There is no /proc filesystem on macOS, so resource usage is not collected for
+// server processes.
+func processGroupUsage(pgid int) (uint64, uint64, error) {
+	return 0, 0, errors.New("process resource usage is not supported on this platform")
+}
+
+// Kills the process group if it is still running. There is no /proc filesystem on macOS
+// to check where the processes in the group are running from, so this relies on the
+// process ID file being removed whenever the server process exits normally. Returns true
+// if the group was killed.
+func killOrphanedProcessGroup(pgid int, dir string) (bool, error) {
+	if err := syscall.Kill(-pgid, 0); err != nil {
+		return false, nil
+	}
+
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return false, err
+	}
+
+	for i := 0; i < 100; i++ {
+		if err := syscall.Kill(-pgid, 0); err == syscall.ESRCH {
+			return true, nil
+		}
+
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	return true, errors.New("process group did not exit after being killed")
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Returns the command used to run the server invocation through the system shell.
+func shellCommand(invocation string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", invocation)
+}
+
+// Runs the server process in its own process group so that signals can be sent to any
+// processes that it starts as well.
+func configureProcessAttributes(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Sends the signal to every process in the process group led by the given process.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
+	}
+
+	return syscall.Kill(-p.Pid, s)
+}
+
+// Returns the total CPU time, in clock ticks, and resident memory, in bytes, used by all
+// of the processes in the process group led by the given process. This is read from the
+// stat files in /proc.
+func processGroupUsage(pgid int) (uint64, uint64, error) {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var ticks, pages uint64
+	for _, d := range dirs {
+		if _, err := strconv.Atoi(d.Name()); err != nil || !d.IsDir() {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join("/proc", d.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+
+		// The second field is the name of the process wrapped in parenthesis, which can
+		// contain spaces, so only split the fields that come after it.
+		s := string(b)
+		fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+		if len(fields) < 22 {
+			continue
+		}
+
+		// Fields are offset by two here since the pid and name have been removed: the
+		// process group is field 5, utime and stime are 14 and 15, and rss is 24.
+		if pg, _ := strconv.Atoi(fields[2]); pg != pgid {
+			continue
+		}
+
+		utime, _ := strconv.ParseUint(fields[11], 10, 64)
+		stime, _ := strconv.ParseUint(fields[12], 10, 64)
+		rss, _ := strconv.ParseUint(fields[21], 10, 64)
+
+		ticks += utime + stime
+		pages += rss
+	}
+
+	return ticks, pages * uint64(os.Getpagesize()), nil
+}
+
+// Returns the IDs of the processes in the given process group that have not exited yet.
+func processGroupMembers(pgid int) ([]int, error) {
+	dirs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []int
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || !d.IsDir() {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join("/proc", d.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+
+		// The state of the process is field 3 and the process group is field 5, once the
+		// pid and name have been removed. Zombie processes have already exited.
+		s := string(b)
+		fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+		if len(fields) < 3 || fields[0] == "Z" {
+			continue
+		}
+
+		if pg, _ := strconv.Atoi(fields[2]); pg == pgid {
+			out = append(out, pid)
+		}
+	}
+
+	return out, nil
+}
+
+// Kills the process group if it is still running and at least one of its processes is
+// running from within the given directory, which guards against killing an unrelated
+// process group that has been given the same ID. Returns true if the group was killed.
+func killOrphanedProcessGroup(pgid int, dir string) (bool, error) {
+	if d, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = d
+	}
+
+	members, err := processGroupMembers(pgid)
+	if err != nil {
+		return false, err
+	}
+
+	owned := false
+	for _, pid := range members {
+		cwd, err := os.Readlink(filepath.Join("/proc", strconv.Itoa(pid), "cwd"))
+		if err == nil && (cwd == dir || strings.HasPrefix(cwd, dir+string(filepath.Separator))) {
+			owned = true
+			break
+		}
+	}
+
+	if !owned {
+		return false, nil
+	}
+
+	if err := syscall.Kill(-pgid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		return false, err
+	}
+
+	for i := 0; i < 100; i++ {
+		if members, err := processGroupMembers(pgid); err == nil && len(members) == 0 {
+			return true, nil
+		}
+
+		time.Sleep(time.Millisecond * 100)
+	}
+
+	return true, errors.New("process group did not exit after being killed")
+}
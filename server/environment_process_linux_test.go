@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A server that echoes back the commands sent to it, and exits when it receives "stop".
+const echoInvocation = `echo "ready"; while read line; do echo "got $line"; if [ "$line" = "stop" ]; then exit 0; fi; done`
+
+func newProcessServer(t *testing.T, invocation string) (*Server, func()) {
+	t.Helper()
+
+	s, _, cleanup := newTestServer(t, "process", map[string]interface{}{
+		"invocation": invocation,
+	}, map[string]interface{}{
+		"startup": map[string]interface{}{"done": "ready"},
+		"stop":    map[string]interface{}{"type": "command", "value": "stop"},
+	})
+
+	return s, cleanup
+}
+
+func TestProcessEnvironmentStartAndStop(t *testing.T) {
+	s, cleanup := newProcessServer(t, echoInvocation)
+	defer cleanup()
+
+	sub := s.Events().Subscribe(StatusEvent, ConsoleOutputEvent)
+	defer sub.Close()
+
+	ctx := context.Background()
+	if err := s.Environment.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForState(t, sub, ProcessRunningState)
+
+	p := s.Environment.(*ProcessEnvironment)
+	if _, err := os.Stat(p.pidPath()); err != nil {
+		t.Fatalf("expected process id file to be written: %v", err)
+	}
+
+	if err := s.Environment.SendCommand("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForOutput(t, sub, "got hello")
+
+	if err := s.Environment.WaitForStop(ctx, time.Second*10, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if running, _ := s.Environment.IsRunning(ctx); running {
+		t.Fatal("expected process to have exited")
+	}
+
+	if code, _, _ := s.Environment.ExitState(ctx); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	if _, err := os.Stat(p.pidPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected process id file to be removed, got %v", err)
+	}
+
+	var lines []string
+	for _, l := range s.ConsoleHistory().Since(0) {
+		lines = append(lines, l.Line)
+	}
+
+	expected := []string{"ready", "got hello", "got stop"}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected console history %v, got %v", expected, lines)
+	}
+
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Fatalf("expected console history %v, got %v", expected, lines)
+		}
+	}
+
+	log, err := s.Environment.Readlog(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(log) != 3 || log[2] != "got stop" {
+		t.Fatalf("unexpected log contents: %v", log)
+	}
+}
+
+func TestProcessEnvironmentTerminatesProcessThatIgnoresStop(t *testing.T) {
+	s, cleanup := newProcessServer(t, `echo "ready"; trap "" TERM; while true; do sleep 1; done`)
+	defer cleanup()
+
+	sub := s.Events().Subscribe(StatusEvent)
+	defer sub.Close()
+
+	ctx := context.Background()
+	if err := s.Environment.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForState(t, sub, ProcessRunningState)
+
+	if err := s.Environment.WaitForStop(ctx, time.Millisecond*500, false); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+
+	if err := s.Environment.WaitForStop(ctx, time.Millisecond*500, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if code, _, _ := s.Environment.ExitState(ctx); code != 128+uint32(syscall.SIGKILL) {
+		t.Fatalf("expected process to be killed, got exit code %d", code)
+	}
+}
+
+// Starts a process group running from the given directory and records it as the server
+// process, as if it had been started by a previous instance of the daemon.
+func startOrphanedProcess(t *testing.T, p *ProcessEnvironment, dir string) *exec.Cmd {
+	t.Helper()
+
+	for _, d := range []string{dir, processDirectory()} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("/bin/sh", "-c", "sleep 60")
+	cmd.Dir = dir
+	configureProcessAttributes(cmd)
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(p.pidPath(), []byte(strconv.Itoa(cmd.Process.Pid)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd
+}
+
+func TestProcessEnvironmentKillsOrphanedProcess(t *testing.T) {
+	s, cleanup := newProcessServer(t, echoInvocation)
+	defer cleanup()
+
+	p := s.Environment.(*ProcessEnvironment)
+	cmd := startOrphanedProcess(t, p, s.Filesystem.Path())
+
+	if err := s.Environment.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.Wait(); err == nil {
+		t.Fatal("expected orphaned process to be killed")
+	}
+
+	if _, err := os.Stat(p.pidPath()); !os.IsNotExist(err) {
+		t.Fatalf("expected process id file to be removed, got %v", err)
+	}
+}
+
+func TestProcessEnvironmentIgnoresUnrelatedProcess(t *testing.T) {
+	s, cleanup := newProcessServer(t, echoInvocation)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "wings-unrelated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := s.Environment.(*ProcessEnvironment)
+	cmd := startOrphanedProcess(t, p, dir)
+	defer func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	}()
+
+	if err := s.Environment.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := syscall.Kill(cmd.Process.Pid, 0); err != nil {
+		t.Fatalf("expected unrelated process to keep running: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"os/exec"
+)
+
+// Returns the command used to run the server invocation through the system shell.
+func shellCommand(invocation string) *exec.Cmd {
+	return exec.Command("cmd", "/C", invocation)
+}
+
+// Process groups are not used on Windows.
+func configureProcessAttributes(cmd *exec.Cmd) {
+}
+
+// Windows does not support sending signals to processes, so the process is always
+// killed regardless of the signal provided.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	return p.Kill()
+}
+
+// Resource usage is not collected for server processes on Windows.
+func processGroupUsage(pgid int) (uint64, uint64, error) {
+	return 0, 0, errors.New("process resource usage is not supported on this platform")
+}
+
+// Process groups are not used on Windows, so processes left running by a previous
+// instance of the daemon cannot be identified and are not killed.
+func killOrphanedProcessGroup(pgid int, dir string) (bool, error) {
+	return false, nil
+}
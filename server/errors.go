@@ -1,5 +1,10 @@
 package server
 
+import (
+	"fmt"
+	"strings"
+)
+
 type suspendedError struct {
 }
 
@@ -17,7 +22,7 @@ type crashTooFrequent struct {
 }
 
 func (e *crashTooFrequent) Error() string {
-	return "server has crashed too soon after the last detected crash"
+	return "server has crashed too many times within the crash detection window"
 }
 
 func IsTooFrequentCrashError(err error) bool {
@@ -37,4 +42,51 @@ func IsServerDoesNotExistError(err error) bool {
 	_, ok := err.(*serverDoesNotExist)
 
 	return ok
-}
\ No newline at end of file
+}
+
+type powerLocked struct {
+	timeout bool
+}
+
+func (e *powerLocked) Error() string {
+	if e.timeout {
+		return "timed out waiting for another power action to finish processing for this server"
+	}
+
+	return "another power action is currently being processed for this server"
+}
+
+func IsPowerLockedError(err error) bool {
+	_, ok := err.(*powerLocked)
+
+	return ok
+}
+
+type invalidStateTransition struct {
+	from ProcessState
+	to   ProcessState
+}
+
+func (e *invalidStateTransition) Error() string {
+	return fmt.Sprintf("server cannot move from the \"%s\" state to the \"%s\" state", e.from, e.to)
+}
+
+func IsInvalidStateTransitionError(err error) bool {
+	_, ok := err.(*invalidStateTransition)
+
+	return ok
+}
+
+type unknownEnvironment struct {
+	name string
+}
+
+func (e *unknownEnvironment) Error() string {
+	return fmt.Sprintf("no environment driver is registered with the name \"%s\" (available: %s)", e.name, strings.Join(RegisteredEnvironments(), ", "))
+}
+
+func IsUnknownEnvironmentError(err error) bool {
+	_, ok := err.(*unknownEnvironment)
+
+	return ok
+}
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"github.com/pterodactyl/wings/config"
 	"sync"
+	"sync/atomic"
 )
 
 // Defines all of the possible output events for a server.
@@ -12,65 +14,273 @@ const (
 	ConsoleOutputEvent = "console output"
 	StatusEvent        = "status"
 	StatsEvent         = "stats"
+
+	// Emitted with a JSON encoded StateTransition each time the server changes state.
+	StateTransitionEvent = "state transition"
 )
 
 type Event struct {
 	Data  string
 	Topic string
+
+	// The sequence number of the line for console output events, see ConsoleHistory.
+	Sequence uint64
 }
 
+// What happens to a subscriber once its queue of events is full.
+type SlowConsumerPolicy string
+
+const (
+	// Discards new events for the subscriber until it has caught up.
+	SlowConsumerDrop SlowConsumerPolicy = "drop"
+
+	// Removes the subscriber from the bus and closes its channel.
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+// Delivers events published for a server to its subscribers. Each subscriber has its own
+// bounded queue of events, so a subscriber that is not keeping up never blocks publishing
+// or delivery to the other subscribers. Events are always delivered to a subscriber in the
+// order that they were published.
 type EventBus struct {
-	subscribers map[string][]chan Event
+	// The total number of events that have been dropped for subscribers to this bus. This
+	// is kept first so that it is aligned for atomic operations on 32-bit platforms.
+	dropped uint64
+
 	mu          sync.Mutex
+	subscribers map[string][]*Subscription
+
+	bufferSize int
+	policy     SlowConsumerPolicy
 }
 
-// Returns the server's emitter instance.
-func (s *Server) Events() *EventBus {
-	if s.emitter == nil {
-		s.emitter = &EventBus{
-			subscribers: map[string][]chan Event{},
-		}
+// Creates an event bus, giving each subscriber a queue of bufferSize events.
+func NewEventBus(bufferSize int, policy SlowConsumerPolicy) *EventBus {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+
+	return &EventBus{
+		subscribers: make(map[string][]*Subscription),
+		bufferSize:  bufferSize,
+		policy:      policy,
 	}
+}
+
+// Creates the event bus for a server using the event settings from the configuration.
+func newServerEventBus() *EventBus {
+	cfg := config.Get().System.Events
 
+	return NewEventBus(cfg.BufferSize, SlowConsumerPolicy(cfg.SlowConsumer))
+}
+
+// Returns the server's emitter instance. This is created when the server is initialized.
+func (s *Server) Events() *EventBus {
 	return s.emitter
 }
 
 // Publish data to a given topic.
 func (e *EventBus) Publish(topic string, data string) {
+	e.PublishEvent(Event{Data: data, Topic: topic})
+}
+
+// Publish an event to the subscribers of its topic. This never blocks on a subscriber,
+// events for subscribers with a full queue are handled according to the slow consumer
+// policy of the bus.
+func (e *EventBus) PublishEvent(event Event) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	if ch, ok := e.subscribers[topic]; ok {
-		go func(data Event, cs []chan Event) {
-			for _, channel := range cs {
-				channel <- data
-			}
-		}(Event{Data: data, Topic: topic}, ch)
+	for _, sub := range e.subscribers[event.Topic] {
+		if sub.unbounded {
+			sub.pending = append(sub.pending, event)
+			sub.notify()
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+			continue
+		default:
+		}
+
+		atomic.AddUint64(&sub.dropped, 1)
+		atomic.AddUint64(&e.dropped, 1)
+
+		if e.policy == SlowConsumerDisconnect {
+			sub.disconnected = true
+			e.remove(sub)
+		}
 	}
 }
 
-// Subscribe to an emitter topic using a channel.
-func (e *EventBus) Subscribe(topic string, ch chan Event) {
+// Subscribes to one or more topics, returning a subscription that receives the events
+// published to any of them.
+func (e *EventBus) Subscribe(topics ...string) *Subscription {
+	sub := &Subscription{
+		bus:    e,
+		topics: topics,
+		events: make(chan Event, e.bufferSize),
+	}
+
+	e.add(sub)
+
+	return sub
+}
+
+// Adds a subscriber to each of its topics.
+func (e *EventBus) add(sub *Subscription) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	if p, ok := e.subscribers[topic]; ok {
-		e.subscribers[topic] = append(p, ch)
-	} else {
-		e.subscribers[topic] = append([]chan Event{}, ch)
+	for _, t := range sub.topics {
+		e.subscribers[t] = append(e.subscribers[t], sub)
 	}
 }
 
-// Unsubscribe a channel from a topic.
-func (e *EventBus) Unsubscribe(topic string, ch chan Event) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+// Subscribes to one or more topics for the daemon itself, such as the listener that detects
+// when a server has started. Events are never dropped for the subscription and it is never
+// disconnected, regardless of the slow consumer policy of the bus. Instead events are queued
+// without a limit and delivered to the channel by a separate goroutine, so the subscriber
+// must always catch up eventually. Events still queued when it is closed are discarded.
+func (e *EventBus) SubscribeInternal(topics ...string) *Subscription {
+	sub := &Subscription{
+		bus:       e,
+		topics:    topics,
+		events:    make(chan Event),
+		unbounded: true,
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	e.add(sub)
+	go sub.deliver()
+
+	return sub
+}
 
-	if _, ok := e.subscribers[topic]; ok {
-		for i := range e.subscribers[topic] {
-			if ch == e.subscribers[topic][i] {
-				e.subscribers[topic] = append(e.subscribers[topic][:i], e.subscribers[topic][i+1:]...)
+// Returns the total number of events that have been dropped for subscribers that were not
+// keeping up.
+func (e *EventBus) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Removes a subscriber from all of its topics and closes its channel. This must be called
+// while holding the lock on the bus, which guarantees that nothing is being sent to the
+// channel when it is closed.
+func (e *EventBus) remove(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+
+	for _, t := range sub.topics {
+		// The list of subscribers is never modified in place since a publish may be
+		// working through it when a slow subscriber is disconnected.
+		subs := e.subscribers[t]
+		for i := range subs {
+			if subs[i] == sub {
+				e.subscribers[t] = append(subs[:i:i], subs[i+1:]...)
+				break
 			}
 		}
+
+		if len(e.subscribers[t]) == 0 {
+			delete(e.subscribers, t)
+		}
+	}
+
+	sub.closed = true
+	// The channel of an internal subscription is closed by the goroutine delivering events
+	// to it, since that is the only place anything is sent to it.
+	if sub.unbounded {
+		close(sub.done)
+		return
 	}
-}
\ No newline at end of file
+
+	close(sub.events)
+}
+
+// A subscription to one or more topics on an event bus.
+type Subscription struct {
+	// The number of events that have been dropped for this subscription.
+	dropped uint64
+
+	bus    *EventBus
+	topics []string
+	events chan Event
+
+	// These are guarded by the lock on the bus.
+	closed       bool
+	disconnected bool
+
+	// Set for internal subscriptions, see SubscribeInternal. The queue of pending events is
+	// guarded by the lock on the bus.
+	unbounded bool
+	pending   []Event
+	wake      chan struct{}
+	done      chan struct{}
+}
+
+// Wakes the goroutine delivering events for an internal subscription, if it is waiting.
+func (s *Subscription) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Delivers the events queued for an internal subscription to its channel, in the order
+// they were published, until the subscription is closed.
+func (s *Subscription) deliver() {
+	defer close(s.events)
+
+	for {
+		s.bus.mu.Lock()
+		pending := s.pending
+		s.pending = nil
+		s.bus.mu.Unlock()
+
+		for _, e := range pending {
+			select {
+			case s.events <- e:
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Returns the channel that events are delivered on. The channel is closed once the
+// subscription is closed, or when it is disconnected for not keeping up.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Returns the number of events that have been dropped for this subscription because it
+// was not keeping up.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Determines if the subscription was removed from the bus for not keeping up.
+func (s *Subscription) Disconnected() bool {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	return s.disconnected
+}
+
+// Unsubscribes from all of the topics and closes the channel. This is safe to call more
+// than once, and at the same time as events are being published.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	s.bus.remove(s)
+}
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestEventBusDeliversEventsInOrder(t *testing.T) {
+	e := NewEventBus(100, SlowConsumerDrop)
+	sub := e.Subscribe(ConsoleOutputEvent, StatusEvent)
+
+	for i := 0; i < 100; i++ {
+		topic := ConsoleOutputEvent
+		if i%2 == 0 {
+			topic = StatusEvent
+		}
+
+		e.Publish(topic, strconv.Itoa(i))
+	}
+	sub.Close()
+
+	i := 0
+	for ev := range sub.Events() {
+		if ev.Data != strconv.Itoa(i) {
+			t.Fatalf("expected event %d, got %s", i, ev.Data)
+		}
+		i++
+	}
+
+	if i != 100 {
+		t.Fatalf("expected 100 events, got %d", i)
+	}
+}
+
+func TestEventBusDropsEventsForSlowSubscribers(t *testing.T) {
+	e := NewEventBus(2, SlowConsumerDrop)
+	slow := e.Subscribe(ConsoleOutputEvent)
+	other := e.Subscribe(ConsoleOutputEvent)
+
+	e.Publish(ConsoleOutputEvent, "a")
+	e.Publish(ConsoleOutputEvent, "b")
+	<-other.Events()
+	e.Publish(ConsoleOutputEvent, "c")
+
+	if slow.Dropped() != 1 || other.Dropped() != 0 || e.Dropped() != 1 {
+		t.Fatalf("unexpected drop counts: slow=%d other=%d bus=%d", slow.Dropped(), other.Dropped(), e.Dropped())
+	}
+
+	if slow.Disconnected() {
+		t.Fatal("expected slow subscriber to remain connected")
+	}
+
+	if ev := <-slow.Events(); ev.Data != "a" {
+		t.Fatalf("expected oldest event to be kept, got %s", ev.Data)
+	}
+}
+
+func TestEventBusDisconnectsSlowSubscribers(t *testing.T) {
+	e := NewEventBus(1, SlowConsumerDisconnect)
+	slow := e.Subscribe(ConsoleOutputEvent, StatusEvent)
+
+	e.Publish(ConsoleOutputEvent, "a")
+	e.Publish(ConsoleOutputEvent, "b")
+	e.Publish(StatusEvent, "c")
+
+	if !slow.Disconnected() || slow.Dropped() != 1 {
+		t.Fatalf("expected subscriber to be disconnected after one drop, dropped=%d", slow.Dropped())
+	}
+
+	var received []string
+	for ev := range slow.Events() {
+		received = append(received, ev.Data)
+	}
+
+	if len(received) != 1 || received[0] != "a" {
+		t.Fatalf("expected only the queued event to be received, got %v", received)
+	}
+
+	// Closing a subscription that has already been disconnected must not panic.
+	slow.Close()
+}
+
+func TestEventBusNeverDropsInternalSubscribers(t *testing.T) {
+	e := NewEventBus(1, SlowConsumerDisconnect)
+	internal := e.SubscribeInternal(ConsoleOutputEvent)
+	slow := e.Subscribe(ConsoleOutputEvent)
+
+	for i := 0; i < 100; i++ {
+		e.Publish(ConsoleOutputEvent, strconv.Itoa(i))
+	}
+
+	if !slow.Disconnected() {
+		t.Fatal("expected slow subscriber to be disconnected")
+	}
+
+	for i := 0; i < 100; i++ {
+		if ev := <-internal.Events(); ev.Data != strconv.Itoa(i) {
+			t.Fatalf("expected event %d, got %s", i, ev.Data)
+		}
+	}
+
+	if internal.Disconnected() || internal.Dropped() != 0 {
+		t.Fatalf("expected internal subscriber to receive every event, dropped=%d", internal.Dropped())
+	}
+
+	internal.Close()
+	internal.Close()
+
+	if _, ok := <-internal.Events(); ok {
+		t.Fatal("expected channel to be closed")
+	}
+}
+
+func TestEventBusCloseWhilePublishing(t *testing.T) {
+	e := NewEventBus(8, SlowConsumerDrop)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			e.Publish(ConsoleOutputEvent, "line")
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		sub := e.Subscribe(ConsoleOutputEvent)
+		sub.Close()
+		sub.Close()
+	}
+
+	wg.Wait()
+}
+
+// Publishes console output to subscribers that keep up with it.
+func benchmarkEventBus(b *testing.B, subscribers int, policy SlowConsumerPolicy) {
+	e := NewEventBus(1024, policy)
+
+	var wg sync.WaitGroup
+	subs := make([]*Subscription, subscribers)
+	for i := range subs {
+		subs[i] = e.Subscribe(ConsoleOutputEvent)
+
+		wg.Add(1)
+		go func(s *Subscription) {
+			defer wg.Done()
+			for range s.Events() {
+			}
+		}(subs[i])
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		e.Publish(ConsoleOutputEvent, "[12:00:00 INFO]: Preparing spawn area: 42%")
+	}
+
+	b.StopTimer()
+
+	for _, s := range subs {
+		s.Close()
+	}
+	wg.Wait()
+
+	b.Logf("%d of %d events dropped", e.Dropped(), b.N*subscribers)
+}
+
+func BenchmarkEventBusPublishOneSubscriber(b *testing.B) {
+	benchmarkEventBus(b, 1, SlowConsumerDrop)
+}
+
+func BenchmarkEventBusPublishManySubscribers(b *testing.B) {
+	benchmarkEventBus(b, 50, SlowConsumerDrop)
+}
+
+// Publishes console output while one of the subscribers never reads from its channel,
+// which must not slow down delivery to the other subscribers.
+func BenchmarkEventBusPublishStuckSubscriber(b *testing.B) {
+	e := NewEventBus(1024, SlowConsumerDrop)
+	e.Subscribe(ConsoleOutputEvent)
+
+	sub := e.Subscribe(ConsoleOutputEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range sub.Events() {
+		}
+	}()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		e.Publish(ConsoleOutputEvent, "[12:00:00 INFO]: Preparing spawn area: 42%")
+	}
+
+	b.StopTimer()
+	sub.Close()
+	<-done
+}
+
+// Publishes console output from several goroutines at once, as happens when a server is
+// producing output while daemon messages are being sent to its console.
+func BenchmarkEventBusPublishParallel(b *testing.B) {
+	e := NewEventBus(1024, SlowConsumerDrop)
+
+	sub := e.Subscribe(ConsoleOutputEvent)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range sub.Events() {
+		}
+	}()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			e.Publish(ConsoleOutputEvent, "[12:00:00 INFO]: Preparing spawn area: 42%")
+		}
+	})
+
+	b.StopTimer()
+	sub.Close()
+	<-done
+}
@@ -0,0 +1,9 @@
+package server
+
+// Exposes the test helpers to the tests in the server_test package. Those tests use the
+// fake Docker engine, which cannot be imported here since it depends on this package.
+var (
+	NewTestServer = newTestServer
+	WaitForState  = waitForState
+	WaitForOutput = waitForOutput
+)
@@ -136,7 +136,9 @@ func (fs *Filesystem) HasSpaceAvailable() bool {
 
 	// Determine if their folder size, in bytes, is smaller than the amount of space they've
 	// been allocated.
-	fs.Server.Resources.Disk = size
+	fs.Server.UpdateResources(func(r *ResourceUsage) {
+		r.Disk = size
+	})
 
 	return (size / 1000.0 / 1000.0) <= space
 }
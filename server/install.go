@@ -10,21 +10,52 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/config"
 	"go.uber.org/zap"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 // Executes the installation stack for a server process. Bubbles any errors up to the calling
 // function which should handle contacting the panel to notify it of the server state.
-func (s *Server) Install() error {
-	err := s.internalInstall()
+//
+// The installation is aborted if the context is cancelled, or if CancelInstall is called
+// while it is running.
+func (s *Server) Install(ctx context.Context) error {
+	err := s.beginInstall(ctx)
+	if err == nil {
+		ictx, cancel := context.WithCancel(ctx)
+
+		s.mutex.Lock()
+		s.installCancel = cancel
+		s.mutex.Unlock()
+
+		err = s.internalInstall(ictx)
+
+		s.mutex.Lock()
+		s.installCancel = nil
+		s.mutex.Unlock()
+		cancel()
+
+		if err != nil {
+			s.setStateOrWarn(ProcessInstallFailedState, "installation process failed")
+		} else {
+			s.setStateOrWarn(ProcessOfflineState, "installation process completed")
 
+			if s.Suspended {
+				s.setStateOrWarn(ProcessSuspendedState, "server is suspended")
+			}
+		}
+	}
+
+	// The Panel is always notified of the result, even if the installation was cancelled,
+	// otherwise the server would be left in the installing state on the Panel.
 	zap.S().Debugw("notifying panel of server install state", zap.String("server", s.Uuid))
-	if serr := s.SyncInstallState(err == nil); serr != nil {
+	if serr := s.SyncInstallState(context.Background(), err == nil); serr != nil {
 		zap.S().Warnw(
 			"failed to notify panel of server install state",
 			zap.String("server", s.Uuid),
@@ -36,9 +67,45 @@ func (s *Server) Install() error {
 	return err
 }
 
+// Moves the server into the installing state, first stopping the server process if it is
+// running since the installation replaces the files that it is using. The power lock is
+// held throughout so that the server cannot be started again part way through.
+func (s *Server) beginInstall(ctx context.Context) error {
+	if err := s.AcquirePowerLock(true); err != nil {
+		return err
+	}
+	defer s.ReleasePowerLock()
+
+	switch s.GetState() {
+	case ProcessStartingState, ProcessRunningState, ProcessStoppingState:
+		zap.S().Infow("stopping server process before running installation", zap.String("server", s.Uuid))
+
+		if err := s.Environment.WaitForStop(ctx, time.Second*time.Duration(config.Get().System.StopTimeout), true); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return s.SetState(ProcessInstallingState, "installation started")
+}
+
+// Aborts the installation process currently running for the server. Returns false if the
+// server is not being installed.
+func (s *Server) CancelInstall() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.installCancel == nil {
+		return false
+	}
+
+	s.installCancel()
+
+	return true
+}
+
 // Internal installation function used to simplify reporting back to the Panel.
-func (s *Server) internalInstall() error {
-	script, rerr, err := api.NewRequester().GetInstallationScript(s.Uuid)
+func (s *Server) internalInstall(ctx context.Context) error {
+	script, rerr, err := api.NewRequester().GetInstallationScript(ctx, s.Uuid)
 	if err != nil || rerr != nil {
 		if err != nil {
 			return err
@@ -54,7 +121,7 @@ func (s *Server) internalInstall() error {
 
 	zap.S().Infow("beginning installation process for server", zap.String("server", s.Uuid))
 
-	if err := p.Run(); err != nil {
+	if err := p.Run(ctx); err != nil {
 		return err
 	}
 
@@ -67,7 +134,7 @@ type InstallationProcess struct {
 	Server *Server
 	Script *api.InstallationScript
 
-	client *client.Client
+	client DockerClient
 	mutex  *sync.Mutex
 }
 
@@ -80,7 +147,7 @@ func NewInstallationProcess(s *Server, script *api.InstallationScript) (*Install
 		mutex:  &sync.Mutex{},
 	}
 
-	if c, err := client.NewClientWithOpts(client.FromEnv); err != nil {
+	if c, err := GetDockerClient(); err != nil {
 		return nil, errors.WithStack(err)
 	} else {
 		proc.client = c
@@ -94,13 +161,13 @@ func NewInstallationProcess(s *Server, script *api.InstallationScript) (*Install
 //
 // Once the container finishes installing the results will be stored in an installation
 // log in the server's configuration directory.
-func (ip *InstallationProcess) Run() error {
-	installPath, err := ip.BeforeExecute()
+func (ip *InstallationProcess) Run(ctx context.Context) error {
+	installPath, err := ip.BeforeExecute(ctx)
 	if err != nil {
 		return err
 	}
 
-	cid, err := ip.Execute(installPath)
+	cid, err := ip.Execute(ctx, installPath)
 	if err != nil {
 		return err
 	}
@@ -145,8 +212,8 @@ func (ip *InstallationProcess) writeScriptToDisk() (string, error) {
 }
 
 // Pulls the docker image to be used for the installation container.
-func (ip *InstallationProcess) pullInstallationImage() error {
-	r, err := ip.client.ImagePull(context.Background(), ip.Script.ContainerImage, types.ImagePullOptions{})
+func (ip *InstallationProcess) pullInstallationImage(ctx context.Context) error {
+	r, err := ip.client.ImagePull(ctx, ip.Script.ContainerImage, types.ImagePullOptions{})
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -167,7 +234,7 @@ func (ip *InstallationProcess) pullInstallationImage() error {
 // Runs before the container is executed. This pulls down the required docker container image
 // as well as writes the installation script to the disk. This process is executed in an async
 // manner, if either one fails the error is returned.
-func (ip *InstallationProcess) BeforeExecute() (string, error) {
+func (ip *InstallationProcess) BeforeExecute(ctx context.Context) (string, error) {
 	wg := sync.WaitGroup{}
 	wg.Add(3)
 
@@ -187,7 +254,7 @@ func (ip *InstallationProcess) BeforeExecute() (string, error) {
 
 	go func() {
 		defer wg.Done()
-		if err := ip.pullInstallationImage(); err != nil {
+		if err := ip.pullInstallationImage(ctx); err != nil {
 			e = append(e, err)
 		}
 	}()
@@ -200,7 +267,7 @@ func (ip *InstallationProcess) BeforeExecute() (string, error) {
 			Force:         true,
 		}
 
-		if err := ip.client.ContainerRemove(context.Background(), ip.Server.Uuid+"_installer", opts); err != nil {
+		if err := ip.client.ContainerRemove(ctx, ip.Server.Uuid+"_installer", opts); err != nil {
 			if !client.IsErrNotFound(err) {
 				e = append(e, err)
 			}
@@ -261,10 +328,9 @@ func (ip *InstallationProcess) AfterExecute(containerId string) error {
 	return nil
 }
 
-// Executes the installation process inside a specially created docker container.
-func (ip *InstallationProcess) Execute(installPath string) (string, error) {
-	ctx := context.Background()
-
+// Executes the installation process inside a specially created docker container. If the
+// context is cancelled while the installation is running the container is removed.
+func (ip *InstallationProcess) Execute(ctx context.Context, installPath string) (string, error) {
 	zap.S().Debugw(
 		"creating server installer container",
 		zap.String("server", ip.Server.Uuid),
@@ -349,14 +415,39 @@ func (ip *InstallationProcess) Execute(installPath string) (string, error) {
 	select {
 	case err := <-eChann:
 		if err != nil {
+			if ctx.Err() != nil {
+				ip.removeCancelledContainer(r.ID)
+			}
+
 			return "", errors.WithStack(err)
 		}
+	case <-ctx.Done():
+		ip.removeCancelledContainer(r.ID)
+
+		return "", errors.WithStack(ctx.Err())
 	case <-sChann:
 	}
 
 	return r.ID, nil
 }
 
+// Forcibly removes an installation container after the installation process has been
+// cancelled. This cannot use the cancelled context of the installation itself.
+func (ip *InstallationProcess) removeCancelledContainer(id string) {
+	zap.S().Infow("installation process was cancelled, removing installer container", zap.String("server", ip.Server.Uuid), zap.String("container_id", id))
+
+	ip.Server.Events().Publish(DaemonMessageEvent, "Installation process was cancelled.")
+
+	err := ip.client.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{
+		RemoveVolumes: true,
+		Force:         true,
+	})
+
+	if err != nil && !client.IsErrNotFound(err) {
+		zap.S().Warnw("failed to remove cancelled installer container", zap.String("server", ip.Server.Uuid), zap.String("container_id", id), zap.Error(err))
+	}
+}
+
 // Streams the output of the installation process to a log file in the server configuration
 // directory, as well as to a websocket listener so that the process can be viewed in
 // the panel by administrators.
@@ -394,10 +485,10 @@ func (ip *InstallationProcess) StreamOutput(id string) error {
 // completed the installation process, and what the state of the server is. A boolean
 // value of "true" means everything was successful, "false" means something went
 // wrong and the server must be deleted and re-created.
-func (s *Server) SyncInstallState(successful bool) error {
+func (s *Server) SyncInstallState(ctx context.Context, successful bool) error {
 	r := api.NewRequester()
 
-	rerr, err := r.SendInstallationStatus(s.Uuid, successful)
+	rerr, err := r.SendInstallationStatus(ctx, s.Uuid, successful)
 	if rerr != nil || err != nil {
 		if err != nil {
 			return errors.WithStack(err)
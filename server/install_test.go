@@ -0,0 +1,128 @@
+package server_test
+
+import (
+	"context"
+	"github.com/docker/docker/api/types/container"
+	"github.com/pterodactyl/wings/dockertest"
+	"github.com/pterodactyl/wings/paneltest"
+	"github.com/pterodactyl/wings/server"
+	"testing"
+	"time"
+)
+
+// Sets up the Panel to return an installation script for the server and accept the
+// result of the installation.
+func handleInstallation(p *paneltest.Panel) {
+	p.Handle("GET", "/servers/"+testServerUuid+"/install", 200, map[string]interface{}{
+		"container_image": "alpine:3.4",
+		"entrypoint":      "ash",
+		"script":          "#!/bin/ash\necho installing\n",
+	})
+
+	p.Handle("POST", "/servers/"+testServerUuid+"/install", 204, nil)
+}
+
+// Returns the result of the installation reported to the Panel.
+func reportedInstallResult(t *testing.T, p *paneltest.Panel) bool {
+	t.Helper()
+
+	r, ok := p.LastRequest("POST", "/servers/"+testServerUuid+"/install")
+	if !ok {
+		t.Fatal("expected the installation result to be sent to the panel")
+	}
+
+	var body struct {
+		Successful bool `json:"successful"`
+	}
+
+	if err := r.Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	return body.Successful
+}
+
+func TestInstallationProcess(t *testing.T) {
+	e, closeEngine := newEngine(t)
+	defer closeEngine()
+
+	s, p, cleanup := newDockerServer(t, nil)
+	defer cleanup()
+
+	handleInstallation(p)
+
+	// The installer container is removed once it has finished, so keep a copy of how it
+	// was configured when it is started.
+	configs := make(chan *container.Config, 1)
+	e.OnStart = func(e *dockertest.Engine, id string) {
+		c, _ := e.Container(id)
+		configs <- c.Config
+
+		e.Output(id, "installing")
+		e.Exit(id, 0, false)
+	}
+
+	if err := s.Install(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := <-configs
+	if cfg.Image != "alpine:3.4" || len(cfg.Cmd) != 2 || cfg.Cmd[0] != "ash" {
+		t.Fatalf("unexpected installer container configuration: image=%s cmd=%v", cfg.Image, cfg.Cmd)
+	}
+
+	if s.GetState() != server.ProcessOfflineState {
+		t.Fatalf("expected server to be offline after installing, got %s", s.GetState())
+	}
+
+	if !reportedInstallResult(t, p) {
+		t.Fatal("expected a successful installation to be reported")
+	}
+}
+
+func TestInstallationProcessCancelled(t *testing.T) {
+	e, closeEngine := newEngine(t)
+	defer closeEngine()
+
+	s, p, cleanup := newDockerServer(t, nil)
+	defer cleanup()
+
+	handleInstallation(p)
+
+	// The installer container is left running until the installation is cancelled.
+	started := make(chan struct{})
+	e.OnStart = func(e *dockertest.Engine, id string) {
+		close(started)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Install(context.Background())
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second * 10):
+		t.Fatal("timed out waiting for installer container to start")
+	}
+
+	if !s.CancelInstall() {
+		t.Fatal("expected the installation to be cancelled")
+	}
+
+	if err := <-errs; err == nil {
+		t.Fatal("expected cancelled installation to return an error")
+	}
+
+	if _, ok := e.Container(testServerUuid + "_installer"); ok {
+		t.Fatal("expected installer container to be removed")
+	}
+
+	if s.GetState() != server.ProcessInstallFailedState {
+		t.Fatalf("expected installation to be marked as failed, got %s", s.GetState())
+	}
+
+	if reportedInstallResult(t, p) {
+		t.Fatal("expected a failed installation to be reported")
+	}
+}
@@ -8,15 +8,13 @@ import (
 
 // Adds all of the internal event listeners we want to use for a server.
 func (s *Server) AddEventListeners() {
-	consoleChannel := make(chan Event)
-	s.Events().Subscribe(ConsoleOutputEvent, consoleChannel)
+	// A line of output that is missed here could leave the server stuck in the starting state,
+	// so this subscription is exempt from the slow consumer policy.
+	sub := s.Events().SubscribeInternal(ConsoleOutputEvent)
 
 	go func() {
-		for {
-			select {
-			case data := <-consoleChannel:
-				s.onConsoleOutput(data.Data)
-			}
+		for data := range sub.Events() {
+			s.onConsoleOutput(data.Data)
 		}
 	}()
 }
@@ -24,23 +22,25 @@ func (s *Server) AddEventListeners() {
 // Custom listener for console output events that will check if the given line
 // of output matches one that should mark the server as started or not.
 func (s *Server) onConsoleOutput(data string) {
+	cfg := s.ProcessConfiguration()
+
 	// If the specific line of output is one that would mark the server as started,
 	// set the server to that state. Only do this if the server is not currently stopped
 	// or stopping.
-	if s.State == ProcessStartingState && strings.Contains(data, s.processConfiguration.Startup.Done) {
+	if s.GetState() == ProcessStartingState && strings.Contains(data, cfg.Startup.Done) {
 		zap.S().Debugw(
-			"detected server in running state based on line output", zap.String("match", s.processConfiguration.Startup.Done), zap.String("against", data),
+			"detected server in running state based on line output", zap.String("match", cfg.Startup.Done), zap.String("against", data),
 		)
 
-		s.SetState(ProcessRunningState)
+		s.setStateOrWarn(ProcessRunningState, "startup completed")
 	}
 
 	// If the command sent to the server is one that should stop the server we will need to
 	// set the server to be in a stopping state, otherwise crash detection will kick in and
 	// cause the server to unexpectedly restart on the user.
-	if s.State == ProcessStartingState || s.State == ProcessRunningState {
-		if s.processConfiguration.Stop.Type == api.ProcessStopCommand && data == s.processConfiguration.Stop.Value {
-			s.SetState(ProcessStoppingState)
+	if s.GetState() == ProcessStartingState || s.GetState() == ProcessRunningState {
+		if cfg.Stop.Type == api.ProcessStopCommand && data == cfg.Stop.Value {
+			s.setStateOrWarn(ProcessStoppingState, "stop command sent to process")
 		}
 	}
 }
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"os"
+	"time"
+)
+
+// Defines all of the power actions that can be performed against a server.
+const (
+	PowerActionStart     = "start"
+	PowerActionStop      = "stop"
+	PowerActionRestart   = "restart"
+	PowerActionTerminate = "kill"
+)
+
+// Determines if the given power action is one that is supported by the daemon.
+func IsValidPowerAction(action string) bool {
+	return action == PowerActionStart ||
+		action == PowerActionStop ||
+		action == PowerActionRestart ||
+		action == PowerActionTerminate
+}
+
+// Obtains the exclusive power lock for the server. Only one power action can be processed
+// for a server at any given time, which prevents something like two start actions from
+// racing to rebuild the same container.
+//
+// If wait is false and another action is currently being processed an error is returned
+// immediately. Otherwise, this blocks until the lock is released or the configured timeout
+// is reached, which stops a hung power action from queueing requests forever.
+func (s *Server) AcquirePowerLock(wait bool) error {
+	if !wait {
+		select {
+		case s.powerLock <- struct{}{}:
+			return nil
+		default:
+			return &powerLocked{}
+		}
+	}
+
+	t := time.NewTimer(time.Second * time.Duration(config.Get().System.PowerLockTimeout))
+	defer t.Stop()
+
+	select {
+	case s.powerLock <- struct{}{}:
+		return nil
+	case <-t.C:
+		return &powerLocked{timeout: true}
+	}
+}
+
+// Releases the power lock for the server, allowing the next queued action to be processed.
+func (s *Server) ReleasePowerLock() {
+	select {
+	case <-s.powerLock:
+	default:
+	}
+}
+
+// Processes a power action against the server environment. The power lock for the server
+// must be obtained using AcquirePowerLock before calling this function, and released by
+// the caller once it returns.
+func (s *Server) HandlePowerAction(ctx context.Context, action string) error {
+	switch action {
+	case PowerActionStart:
+		return s.Environment.Start(ctx)
+	case PowerActionStop:
+		return s.Environment.Stop(ctx)
+	case PowerActionRestart:
+		return s.Environment.Restart(ctx)
+	case PowerActionTerminate:
+		return s.Environment.Terminate(ctx, os.Kill)
+	}
+
+	return errors.New("attempting to handle unknown power action: " + action)
+}
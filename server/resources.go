@@ -3,6 +3,7 @@ package server
 import (
 	"github.com/docker/docker/api/types"
 	"math"
+	"sync"
 )
 
 // Defines the current resource usage for a given server instance. If a server is offline you
@@ -51,4 +52,56 @@ func (ru *ResourceUsage) CalculateAbsoluteCpu(pStats *types.CPUStats, stats *typ
 	}
 
 	return math.Round(percent*1000) / 1000
-}
\ No newline at end of file
+}
+
+// Tracks the resource usage of a server process. This is kept behind a pointer on the server
+// so that copying the server, such as when writing it to the disk, never reads the values
+// while the resource polling is changing them.
+type resourceTracker struct {
+	mu sync.Mutex
+
+	// The current usage values for the server.
+	current ResourceUsage
+
+	// The last usage values collected for the server before resource polling was disabled.
+	// These are included in crash reports since the current values are reset once the
+	// server process stops.
+	final ResourceUsage
+}
+
+// Returns a copy of the current resource usage for the server.
+func (s *Server) GetResources() ResourceUsage {
+	s.resources.mu.Lock()
+	defer s.resources.mu.Unlock()
+
+	return s.resources.current
+}
+
+// Updates the resource usage for the server using the given function, and returns a copy
+// of the updated values that can be published.
+func (s *Server) UpdateResources(update func(r *ResourceUsage)) ResourceUsage {
+	s.resources.mu.Lock()
+	defer s.resources.mu.Unlock()
+
+	update(&s.resources.current)
+
+	return s.resources.current
+}
+
+// Records the current resource usage as the final values for the server process, and then
+// resets the values that only apply while the process is running using the given function.
+func (s *Server) finishResources(reset func(r *ResourceUsage)) {
+	s.resources.mu.Lock()
+	defer s.resources.mu.Unlock()
+
+	s.resources.final = s.resources.current
+	reset(&s.resources.current)
+}
+
+// Returns the last resource usage values collected before the server process stopped.
+func (s *Server) finalResources() ResourceUsage {
+	s.resources.mu.Lock()
+	defer s.resources.mu.Unlock()
+
+	return s.resources.final
+}
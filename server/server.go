@@ -1,18 +1,19 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"github.com/creasty/defaults"
 	"github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/atomicfile"
 	"github.com/pterodactyl/wings/config"
 	"github.com/remeh/sizedwaitgroup"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v2"
-	"io/ioutil"
 	"os"
-	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -35,9 +36,6 @@ type Server struct {
 	// be started or modified except in certain scenarios by an admin user.
 	Suspended bool `json:"suspended"`
 
-	// The power state of the server.
-	State string `default:"offline" json:"state"`
-
 	// The command that should be used when booting up the server instance.
 	Invocation string `json:"invocation"`
 
@@ -45,12 +43,15 @@ type Server struct {
 	// server process.
 	EnvVars map[string]string `json:"environment" yaml:"environment"`
 
+	// The name of the environment driver that should be used to run the server process.
+	// This must match the name of a registered environment.
+	Driver string `default:"docker" json:"driver" yaml:"driver"`
+
 	CrashDetection CrashDetection `json:"crash_detection" yaml:"crash_detection"`
 	Build          BuildSettings  `json:"build"`
 	Allocations    Allocations    `json:"allocations"`
 	Environment    Environment    `json:"-" yaml:"-"`
 	Filesystem     Filesystem     `json:"-" yaml:"-"`
-	Resources      ResourceUsage  `json:"resources" yaml:"-"`
 
 	Container struct {
 		// Defines the Docker image that will be used for this server
@@ -67,14 +68,42 @@ type Server struct {
 	// Events emitted by the server instance.
 	emitter *EventBus
 
+	// The most recent console output for the server.
+	console *ConsoleHistory
+
+	// Limits the rate at which the server process can write to the console.
+	throttler *ConsoleThrottler
+
 	// Defines the process configuration for the server instance. This is dynamically
 	// fetched from the Pterodactyl Server instance each time the server process is
 	// started, and then cached here.
 	processConfiguration *api.ProcessConfiguration
 
+	// The resource usage of the server process, see GetResources.
+	resources *resourceTracker
+
 	// Internal mutex used to block actions that need to occur sequentially, such as
 	// writing the configuration to the disk.
 	mutex *sync.Mutex
+
+	// Coalesces writes of the server configuration to the disk.
+	configWriter *atomicfile.Writer
+
+	// Exclusive lock held while a power action is being processed for the server. This
+	// is a buffered channel with a capacity of one so that obtaining the lock can be
+	// attempted without blocking, or with a timeout.
+	powerLock chan struct{}
+
+	// The power state of the server. This must only be read through GetState and changed
+	// through SetState, which hold the state mutex.
+	state ProcessState
+
+	// Mutex used to ensure that state transitions for the server are validated and
+	// applied one at a time.
+	stateMutex *sync.Mutex
+
+	// Cancels the installation process for the server while one is running.
+	installCancel context.CancelFunc
 }
 
 // The build settings for a given server that impact docker container creation and
@@ -138,60 +167,56 @@ type Allocations struct {
 	Mappings map[string][]int `json:"mappings"`
 }
 
-// Iterates over a given directory and loads all of the servers listed before returning
-// them to the calling function.
-func LoadDirectory(dir string, cfg *config.SystemConfiguration) error {
+// Loads all of the servers from the store before returning them to the calling function.
+func LoadServers(cfg *config.SystemConfiguration) error {
 	// We could theoretically use a standard wait group here, however doing
 	// that introduces the potential to crash the program due to too many
 	// open files. This wouldn't happen on a small setup, but once the daemon is
 	// handling many servers you run that risk.
 	//
-	// For now just process 10 files at a time, that should be plenty fast to
+	// For now just process 10 servers at a time, that should be plenty fast to
 	// read and parse the YAML. We should probably make this configurable down
 	// the road to help big instances scale better.
 	wg := sizedwaitgroup.New(10)
 
-	f, err := ioutil.ReadDir(dir)
+	uuids, err := GetStore().List()
 	if err != nil {
 		return err
 	}
 
 	servers = NewCollection(nil)
 
-	for _, file := range f {
-		if !strings.HasSuffix(file.Name(), ".yml") || file.IsDir() {
-			continue
-		}
-
+	for _, uuid := range uuids {
 		wg.Add()
-		// For each of the YAML files we find, parse it and create a new server
+		// For each of the servers we find, parse the definition and create a new server
 		// configuration object that can then be returned to the caller.
-		go func(file os.FileInfo) {
+		go func(uuid string) {
 			defer wg.Done()
 
-			b, err := ioutil.ReadFile(path.Join(dir, file.Name()))
+			b, err := GetStore().Get(uuid)
 			if err != nil {
-				zap.S().Errorw("failed to read server configuration file, skipping...", zap.String("server", file.Name()), zap.Error(err))
+				zap.S().Errorw("failed to read server configuration, skipping...", zap.String("server", uuid), zap.Error(err))
 				return
 			}
 
 			s, err := FromConfiguration(b, cfg)
 			if err != nil {
 				if IsServerDoesNotExistError(err) {
-					zap.S().Infow("server does not exist on remote system", zap.String("server", file.Name()))
+					zap.S().Infow("server does not exist on remote system", zap.String("server", uuid))
+				} else if api.IsCircuitOpenError(err) {
+					zap.S().Errorw("panel is unavailable, unable to load server configuration, skipping...", zap.String("server", uuid))
 				} else {
-					zap.S().Errorw("failed to parse server configuration, skipping...", zap.String("server", file.Name()), zap.Error(err))
+					zap.S().Errorw("failed to parse server configuration, skipping...", zap.String("server", uuid), zap.Error(err))
 				}
 
 				return
 			}
 
 			servers.Add(s)
-		}(file)
+		}(uuid)
 	}
 
-	// Wait until we've processed all of the configuration files in the directory
-	// before continuing.
+	// Wait until we've processed all of the servers in the store before continuing.
 	wg.Wait()
 
 	return nil
@@ -200,6 +225,14 @@ func LoadDirectory(dir string, cfg *config.SystemConfiguration) error {
 // Initializes the default required internal struct components for a Server.
 func (s *Server) Init() {
 	s.mutex = &sync.Mutex{}
+	s.configWriter = &atomicfile.Writer{}
+	s.powerLock = make(chan struct{}, 1)
+	s.stateMutex = &sync.Mutex{}
+	s.state = ProcessOfflineState
+	s.emitter = newServerEventBus()
+	s.resources = &resourceTracker{}
+	s.console = NewConsoleHistory(config.Get().System.ConsoleHistoryLines)
+	s.throttler = &ConsoleThrottler{}
 }
 
 // Initalizes a server using a data byte array. This will be marshaled into the
@@ -220,25 +253,25 @@ func FromConfiguration(data []byte, cfg *config.SystemConfiguration) (*Server, e
 
 	s.AddEventListeners()
 
-	// Right now we only support a Docker based environment, so I'm going to hard code
-	// this logic in. When we're ready to support other environment we'll need to make
-	// some modifications here obviously.
-	if err := NewDockerEnvironment(s); err != nil {
+	// Create the environment for the server using the driver defined in the configuration,
+	// this will return an error if the driver has not been registered.
+	env, err := NewEnvironment(s.Driver, s)
+	if err != nil {
 		return nil, err
 	}
+	s.Environment = env
 
 	s.Cache = cache.New(time.Minute*10, time.Minute*15)
 	s.Filesystem = Filesystem{
 		Configuration: cfg,
 		Server:        s,
 	}
-	s.Resources = ResourceUsage{}
 
 	// This is also done when the server is booted, however we need to account for instances
 	// where the server is already running and the Daemon reboots. In those cases this will
 	// allow us to you know, stop servers.
 	if cfg.SyncServersOnBoot {
-		if err := s.Sync(); err != nil {
+		if err := s.Sync(context.Background()); err != nil {
 			return nil, err
 		}
 	}
@@ -279,30 +312,68 @@ eloop:
 //
 // This also means mass actions can be performed against servers on the Panel and they
 // will automatically sync with Wings when the server is started.
-func (s *Server) Sync() error {
-	cfg, rerr, err := s.GetProcessConfiguration()
+//
+// If the Panel cannot be reached the last configuration received from it is used instead,
+// so that servers can still be booted and restarted during a Panel outage. In that case
+// the server settings are left as they are currently stored on the disk.
+func (s *Server) Sync(ctx context.Context) error {
+	cfg, rerr, err := s.GetProcessConfiguration(ctx)
 	if err != nil || rerr != nil {
-		if err != nil {
-			return errors.WithStack(err)
+		if rerr != nil && rerr.Status == "404" {
+			return &serverDoesNotExist{}
 		}
 
-		if rerr.Status == "404" {
-			return &serverDoesNotExist{}
+		if !isPanelUnavailable(rerr) && err == nil {
+			return errors.New(rerr.String())
 		}
 
-		return errors.New(rerr.String())
+		cached, cerr := s.readCachedConfiguration()
+		if cerr != nil {
+			if !os.IsNotExist(errors.Cause(cerr)) {
+				zap.S().Warnw("failed to read cached panel configuration for server", zap.String("server", s.Uuid), zap.Error(cerr))
+			}
+
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			return errors.New(rerr.String())
+		}
+
+		zap.S().Warnw("unable to reach panel, using cached configuration for server", zap.String("server", s.Uuid), zap.Error(err))
+		s.PublishConsoleOutputFromDaemon("Unable to reach the Panel, using the last known configuration for this server.")
+
+		s.setProcessConfiguration(cached.ProcessConfiguration)
+
+		return nil
 	}
 
 	// Update the data structure and persist it to the disk.
-	if err:= s.UpdateDataStructure(cfg.Settings, false); err != nil {
+	if err := s.UpdateDataStructure(cfg.Settings, false); err != nil {
 		return errors.WithStack(err)
 	}
 
-	s.processConfiguration = cfg.ProcessConfiguration
+	s.setProcessConfiguration(cfg.ProcessConfiguration)
+
+	if err := s.writeCachedConfiguration(cfg); err != nil {
+		zap.S().Warnw("failed to cache panel configuration for server", zap.String("server", s.Uuid), zap.Error(err))
+	}
 
 	return nil
 }
 
+// Determines if an error returned by the Panel indicates that it is currently unable to
+// handle requests, rather than the request itself being invalid.
+func isPanelUnavailable(rerr *api.RequestError) bool {
+	if rerr == nil {
+		return false
+	}
+
+	status, _ := strconv.Atoi(rerr.Status)
+
+	return api.IsUnavailableStatus(status)
+}
+
 // Reads the log file for a server up to a specified number of bytes.
 func (s *Server) ReadLogfile(len int64) ([]string, error) {
 	return s.Environment.Readlog(len)
@@ -310,80 +381,35 @@ func (s *Server) ReadLogfile(len int64) ([]string, error) {
 
 // Determine if the server is bootable in it's current state or not. This will not
 // indicate why a server is not bootable, only if it is.
-func (s *Server) IsBootable() bool {
-	exists, _ := s.Environment.Exists()
+func (s *Server) IsBootable(ctx context.Context) bool {
+	exists, _ := s.Environment.Exists(ctx)
 
 	return exists
 }
 
 // Initalizes a server instance. This will run through and ensure that the environment
 // for the server is setup, and that all of the necessary files are created.
-func (s *Server) CreateEnvironment() error {
-	return s.Environment.Create()
+func (s *Server) CreateEnvironment(ctx context.Context) error {
+	return s.Environment.Create(ctx)
 }
 
-const (
-	ProcessOfflineState  = "offline"
-	ProcessStartingState = "starting"
-	ProcessRunningState  = "running"
-	ProcessStoppingState = "stopping"
-)
-
-// Sets the state of the server internally. This function handles crash detection as
-// well as reporting to event listeners for the server.
-func (s *Server) SetState(state string) error {
-	if state != ProcessOfflineState && state != ProcessStartingState && state != ProcessRunningState && state != ProcessStoppingState {
-		return errors.New(fmt.Sprintf("invalid server state received: %s", state))
-	}
-
-	prevState := s.State
-	s.State = state
-
-	// Persist this change to the disk immediately so that should the Daemon be stopped or
-	// crash we can immediately restore the server state.
-	//
-	// This really only makes a difference if all of the Docker containers are also stopped,
-	// but this was a highly requested feature and isn't hard to work with, so lets do it.
-	//
-	// We also get the benefit of server status changes always propagating corrected configurations
-	// to the disk should we forget to do it elsewhere.
-	go func(server *Server) {
-		if _, err := server.WriteConfigurationToDisk(); err != nil {
-			zap.S().Warnw("failed to write server state change to disk", zap.String("server", server.Uuid), zap.Error(err))
-		}
-	}(s)
-
-	zap.S().Debugw("saw server status change event", zap.String("server", s.Uuid), zap.String("status", s.State))
-
-	// Emit the event to any listeners that are currently registered.
-	s.Events().Publish(StatusEvent, s.State)
+// Gets the process configuration data for the server.
+func (s *Server) GetProcessConfiguration(ctx context.Context) (*api.ServerConfigurationResponse, *api.RequestError, error) {
+	return api.NewRequester().GetServerConfiguration(ctx, s.Uuid)
+}
 
-	// If server was in an online state, and is now in an offline state we should handle
-	// that as a crash event. In that scenario, check the last crash time, and the crash
-	// counter.
-	//
-	// In the event that we have passed the thresholds, don't do anything, otherwise
-	// automatically attempt to start the process back up for the user. This is done in a
-	// seperate thread as to not block any actions currently taking place in the flow
-	// that called this function.
-	if (prevState == ProcessStartingState || prevState == ProcessRunningState) && s.State == ProcessOfflineState {
-		zap.S().Infow("detected server as entering a potentially crashed state; running handler", zap.String("server", s.Uuid))
-
-		go func(server *Server) {
-			if err := server.handleServerCrash(); err != nil {
-				if IsTooFrequentCrashError(err) {
-					zap.S().Infow("did not restart server after crash; occurred too soon after last", zap.String("server", server.Uuid))
-				} else {
-					zap.S().Errorw("failed to handle server crash state", zap.String("server", server.Uuid), zap.Error(err))
-				}
-			}
-		}(s)
-	}
+// Returns the process configuration for the server that was last received from the Panel.
+func (s *Server) ProcessConfiguration() *api.ProcessConfiguration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	return nil
+	return s.processConfiguration
 }
 
-// Gets the process configuration data for the server.
-func (s *Server) GetProcessConfiguration() (*api.ServerConfigurationResponse, *api.RequestError, error) {
-	return api.NewRequester().GetServerConfiguration(s.Uuid)
+// Replaces the process configuration for the server. This is done while holding the server
+// mutex, which is also held while the server is copied to write it to the disk.
+func (s *Server) setProcessConfiguration(c *api.ProcessConfiguration) {
+	s.mutex.Lock()
+	s.processConfiguration = c
+	s.mutex.Unlock()
 }
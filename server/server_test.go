@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/paneltest"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testServerUuid = "0f2d5cf6-7bd4-4b0e-9a4c-8f4c5e1a2b3c"
+
+// Creates a server using the given environment driver, with the data directory and store
+// kept in a temporary directory and the configuration pointed at a fake Panel. The Panel
+// returns the given settings and process configuration for the server. The returned
+// function must be called once the test is finished with the server.
+func newTestServer(t *testing.T, driver string, settings map[string]interface{}, process map[string]interface{}) (*Server, *paneltest.Panel, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "wings-server-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := paneltest.New("test-token")
+
+	c := p.Configuration()
+	c.System.Data = filepath.Join(dir, "volumes")
+	c.System.Store.Driver = "yaml"
+	c.System.Store.Root = filepath.Join(dir, "store")
+	c.System.User.Uid = os.Getuid()
+	c.System.User.Gid = os.Getgid()
+
+	config.Set(c)
+
+	store, err := OpenStore(c.System.Store.Driver, c.System.Store.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	SetStore(store)
+
+	// The configuration and store are left in place once the test is finished, since state
+	// changes are written to the store in the background and may still be running. Writes
+	// made after the directory has been removed fail without affecting anything else.
+	cleanup := func() {
+		p.Close()
+		os.RemoveAll(dir)
+	}
+
+	settings["uuid"] = testServerUuid
+	settings["driver"] = driver
+	if _, ok := settings["crash_detection"]; !ok {
+		settings["crash_detection"] = map[string]interface{}{"enabled": false}
+	}
+
+	p.Handle("GET", "/servers/"+testServerUuid, 200, map[string]interface{}{
+		"settings":              settings,
+		"process_configuration": process,
+	})
+
+	// Only the fields needed to create the environment are passed here, everything else is
+	// loaded from the Panel when the server is synced.
+	b, _ := json.Marshal(map[string]string{"uuid": testServerUuid, "driver": driver})
+
+	s, err := FromConfiguration(b, &c.System)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return s, p, cleanup
+}
+
+// Waits for the server to publish a status event for the given state.
+func waitForState(t *testing.T, sub *Subscription, state ProcessState) {
+	t.Helper()
+
+	timeout := time.After(time.Second * 10)
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("subscription closed while waiting for %s state", state)
+			}
+
+			if e.Topic == StatusEvent && e.Data == string(state) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s state", state)
+		}
+	}
+}
+
+// Waits for a line of output containing the given text to be published to the console of
+// the server.
+func waitForOutput(t *testing.T, sub *Subscription, line string) {
+	t.Helper()
+
+	timeout := time.After(time.Second * 10)
+	for {
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("subscription closed while waiting for %q", line)
+			}
+
+			if e.Topic == ConsoleOutputEvent && e.Data == line {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %q", line)
+		}
+	}
+}
+
+func TestSyncFallsBackToCachedConfigurationWhenPanelUnavailable(t *testing.T) {
+	s, p, cleanup := newTestServer(t, "process", map[string]interface{}{
+		"invocation": "echo ready",
+	}, map[string]interface{}{
+		"startup": map[string]interface{}{"done": "ready"},
+	})
+	defer cleanup()
+
+	ctx := context.Background()
+
+	p.HandleError("GET", "/servers/"+testServerUuid, 403, "AccessDeniedHttpException", "This action is unauthorized.")
+	if err := s.Sync(ctx); err == nil {
+		t.Fatal("expected a rejected request to return an error")
+	}
+
+	for _, status := range []int{429, 500, 503} {
+		p.HandleError("GET", "/servers/"+testServerUuid, status, "HttpException", "The panel is unavailable.")
+
+		if err := s.Sync(ctx); err != nil {
+			t.Fatalf("expected status %d to fall back to the cached configuration, got %v", status, err)
+		}
+
+		if s.ProcessConfiguration().Startup.Done != "ready" {
+			t.Fatalf("expected cached process configuration to be used for status %d", status)
+		}
+	}
+}
+
+func TestConsoleHistoryIsFilledFromServerLog(t *testing.T) {
+	s, _, cleanup := newTestServer(t, "process", map[string]interface{}{
+		"invocation": "echo ready",
+	}, map[string]interface{}{
+		"startup": map[string]interface{}{"done": "ready"},
+	})
+	defer cleanup()
+
+	// Output written by the server before the daemon was restarted.
+	p := s.Environment.(*ProcessEnvironment)
+	if err := os.MkdirAll(processDirectory(), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(p.logPath(), []byte("first\nsecond\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := s.ConsoleHistory().Since(0)
+	if len(lines) != 2 || lines[0].Line != "first" || lines[1].Line != "second" {
+		t.Fatalf("expected console history to be filled from the server log, got %v", lines)
+	}
+
+	// The history is only filled once, so reading it again does not duplicate the lines.
+	if lines := s.ConsoleHistory().Since(0); len(lines) != 2 {
+		t.Fatalf("expected console history to be filled once, got %v", lines)
+	}
+}
+
+func TestConfigurationWrittenToDiskHasLatestState(t *testing.T) {
+	s, _, cleanup := newTestServer(t, "process", map[string]interface{}{
+		"invocation": "echo ready",
+	}, map[string]interface{}{
+		"startup": map[string]interface{}{"done": "ready"},
+	})
+	defer cleanup()
+
+	// Changing the state writes the configuration to the disk in the background, so these
+	// writes overlap with each other and with the changes to the server.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			s.setStateOrWarn(ProcessStartingState, "test")
+			s.setStateOrWarn(ProcessOfflineState, "test")
+		}
+		s.setStateOrWarn(ProcessInstallingState, "test")
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if err := s.UpdateDataStructure([]byte(`{"invocation":"echo ready"}`), false); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if _, err := s.WriteConfigurationToDisk(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := GetStore().Get(testServerUuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stored struct {
+		State ProcessState `yaml:"state"`
+	}
+
+	if err := yaml.Unmarshal(b, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	if stored.State != ProcessInstallingState {
+		t.Fatalf("expected the latest state to be written to the disk, got %s", stored.State)
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"go.uber.org/zap"
+)
+
+// Defines the power state of a server process.
+type ProcessState string
+
+// Defines all of the possible states that a server can be in.
+const (
+	ProcessOfflineState       ProcessState = "offline"
+	ProcessStartingState      ProcessState = "starting"
+	ProcessRunningState       ProcessState = "running"
+	ProcessStoppingState      ProcessState = "stopping"
+	ProcessInstallingState    ProcessState = "installing"
+	ProcessInstallFailedState ProcessState = "install_failed"
+	ProcessSuspendedState     ProcessState = "suspended"
+	ProcessTransferringState  ProcessState = "transferring"
+)
+
+// Defines the states that a server is allowed to move into from any given state. A server
+// can always be moved back into the offline state, so that is not listed here.
+var stateTransitions = map[ProcessState][]ProcessState{
+	ProcessOfflineState: {
+		ProcessStartingState,
+		// Used when the daemon boots and finds that the server process is already running,
+		// and re-attaches to it.
+		ProcessRunningState,
+		ProcessInstallingState,
+		ProcessSuspendedState,
+		ProcessTransferringState,
+	},
+	ProcessStartingState: {ProcessRunningState, ProcessStoppingState},
+	ProcessRunningState: {
+		ProcessStoppingState,
+		// Used when the server process is found to have stopped without the daemon seeing
+		// it exit, such as when the daemon boots, and it is started again.
+		ProcessStartingState,
+	},
+	ProcessStoppingState: {
+		// Used when the process ignores the stop action and keeps running, or exits
+		// without the daemon seeing it, and is then started again.
+		ProcessRunningState,
+		ProcessStartingState,
+	},
+	ProcessInstallingState:    {ProcessInstallFailedState},
+	ProcessInstallFailedState: {ProcessInstallingState},
+	// Suspended servers can still be reinstalled, they are moved back into the suspended
+	// state once the installation has finished.
+	ProcessSuspendedState:    {ProcessInstallingState},
+	ProcessTransferringState: {},
+}
+
+// Determines if the given state is one that is known to the daemon.
+func (ps ProcessState) IsValid() bool {
+	_, ok := stateTransitions[ps]
+
+	return ok
+}
+
+// Determines if a server is allowed to move from the current state into the next
+// state provided.
+func (ps ProcessState) CanTransitionTo(next ProcessState) bool {
+	if !next.IsValid() {
+		return false
+	}
+
+	if next == ProcessOfflineState {
+		return true
+	}
+
+	for _, s := range stateTransitions[ps] {
+		if s == next {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Sets the state of the server, logging a warning if the transition is not allowed. This is
+// used where the change in state is the result of something that has already happened,
+// such as the server process exiting, and there is nothing for the caller to undo.
+func (s *Server) setStateOrWarn(state ProcessState, reason string) {
+	if err := s.SetState(state, reason); err != nil {
+		zap.S().Warnw(
+			"failed to update server state",
+			zap.String("server", s.Uuid),
+			zap.String("state", string(state)),
+			zap.String("reason", reason),
+			zap.Error(err),
+		)
+	}
+}
+
+// Defines the reason used when the environment detects that the server process has
+// exited. Moving from a starting or running state into the offline state for this
+// reason is the only transition that will be handled as a potential crash.
+const StateReasonProcessExited = "process exited"
+
+// Defines a change in the state of a server, and why that change happened. This is
+// emitted to any listeners of the StateTransitionEvent as a JSON string.
+type StateTransition struct {
+	From   ProcessState `json:"from"`
+	To     ProcessState `json:"to"`
+	Reason string       `json:"reason"`
+}
+
+// Determines if this transition represents the server process stopping without the
+// daemon being asked to stop it.
+func (t StateTransition) IsPotentialCrash() bool {
+	return (t.From == ProcessStartingState || t.From == ProcessRunningState) &&
+		t.To == ProcessOfflineState &&
+		t.Reason == StateReasonProcessExited
+}
+
+// Returns the current power state of the server.
+func (s *Server) GetState() ProcessState {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	return s.state
+}
+
+// Sets the state of the server internally. The transition is validated against the
+// current state of the server, and an error is returned if it is not allowed. This
+// function handles crash detection as well as reporting to event listeners for the
+// server. Moving a server into the state it is already in is a no-op.
+func (s *Server) SetState(state ProcessState, reason string) error {
+	s.stateMutex.Lock()
+
+	if s.state == state {
+		s.stateMutex.Unlock()
+
+		return nil
+	}
+
+	if !s.state.CanTransitionTo(state) {
+		s.stateMutex.Unlock()
+
+		return &invalidStateTransition{from: s.state, to: state}
+	}
+
+	t := StateTransition{From: s.state, To: state, Reason: reason}
+	s.state = state
+	s.stateMutex.Unlock()
+
+	// Persist this change to the disk immediately so that should the Daemon be stopped or
+	// crash we can immediately restore the server state.
+	//
+	// This really only makes a difference if all of the Docker containers are also stopped,
+	// but this was a highly requested feature and isn't hard to work with, so lets do it.
+	//
+	// We also get the benefit of server status changes always propagating corrected configurations
+	// to the disk should we forget to do it elsewhere.
+	go func(server *Server) {
+		if _, err := server.WriteConfigurationToDisk(); err != nil {
+			zap.S().Warnw("failed to write server state change to disk", zap.String("server", server.Uuid), zap.Error(err))
+		}
+	}(s)
+
+	zap.S().Debugw(
+		"saw server status change event",
+		zap.String("server", s.Uuid),
+		zap.String("from", string(t.From)),
+		zap.String("status", string(t.To)),
+		zap.String("reason", t.Reason),
+	)
+
+	// Emit the events to any listeners that are currently registered.
+	b, _ := json.Marshal(t)
+	s.Events().Publish(StateTransitionEvent, string(b))
+	s.Events().Publish(StatusEvent, string(t.To))
+
+	// If the server process exited while it was in an online state we should handle that
+	// as a crash event. In that scenario, check the last crash time, and the crash counter.
+	//
+	// In the event that we have passed the thresholds, don't do anything, otherwise
+	// automatically attempt to start the process back up for the user. This is done in a
+	// seperate thread as to not block any actions currently taking place in the flow
+	// that called this function, which the daemon waits on when shutting down.
+	//
+	// Stopping the server through the daemon always moves it into the stopping state first,
+	// so the offline state that follows is never treated as a crash.
+	if t.IsPotentialCrash() {
+		zap.S().Infow("detected server as entering a potentially crashed state; running handler", zap.String("server", s.Uuid))
+
+		runTask(func(ctx context.Context) {
+			if err := s.handleServerCrash(ctx); err != nil {
+				if IsTooFrequentCrashError(err) {
+					zap.S().Infow("did not restart server after crash; exceeded restarts allowed in crash window", zap.String("server", s.Uuid))
+				} else {
+					zap.S().Errorw("failed to handle server crash state", zap.String("server", s.Uuid), zap.Error(err))
+				}
+			}
+		})
+	}
+
+	return nil
+}
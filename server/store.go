@@ -0,0 +1,74 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// Persists the definitions of the servers on this daemon. A definition is the YAML encoded
+// server configuration, keyed by the UUID of the server.
+type Store interface {
+	// Returns the UUIDs of all of the servers in the store.
+	List() ([]string, error)
+
+	// Returns the definition for a server. If the server does not exist in the store an
+	// error is returned that can be checked using IsStoreNotFoundError.
+	Get(uuid string) ([]byte, error)
+
+	// Creates or replaces the definition for a server.
+	Put(uuid string, data []byte) error
+
+	// Removes the definition for a server. Deleting a server that does not exist in the
+	// store is not an error.
+	Delete(uuid string) error
+
+	// Releases any resources held by the store.
+	Close() error
+}
+
+type storeNotFound struct {
+	uuid string
+}
+
+func (e *storeNotFound) Error() string {
+	return "no definition exists in the store for server " + e.uuid
+}
+
+// Determines if an error was returned because a server does not exist in the store.
+func IsStoreNotFoundError(err error) bool {
+	_, ok := errors.Cause(err).(*storeNotFound)
+
+	return ok
+}
+
+// Opens the store for the given driver, using root as the directory that it keeps its data
+// in. The directory is created if it does not already exist.
+func OpenStore(driver string, root string) (Store, error) {
+	switch driver {
+	case "yaml":
+		return NewYamlStore(root)
+	case "kv":
+		return NewKVStore(root)
+	}
+
+	return nil, errors.Errorf("unknown store driver \"%s\"", driver)
+}
+
+var _store Store
+var _storeMutex sync.Mutex
+
+// Sets the store that server definitions are loaded from and saved to.
+func SetStore(s Store) {
+	_storeMutex.Lock()
+	defer _storeMutex.Unlock()
+
+	_store = s
+}
+
+// Returns the store that server definitions are loaded from and saved to.
+func GetStore() Store {
+	_storeMutex.Lock()
+	defer _storeMutex.Unlock()
+
+	return _store
+}
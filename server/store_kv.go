@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/atomicfile"
+	"go.uber.org/zap"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	kvHeader = "WINGSKV1"
+
+	kvOpPut    byte = 1
+	kvOpDelete byte = 2
+
+	// The size of the checksum, operation and key and value lengths at the start of each
+	// record in the file.
+	kvRecordHeaderSize = 4 + 1 + 4 + 4
+
+	// The log is only compacted once it is at least this large, and more than half of it
+	// is made up of records that have since been replaced.
+	kvCompactThreshold = 1 << 20
+)
+
+// Stores all of the server definitions in a single append-only file. Each change is written
+// to the end of the file as a checksummed record and synced to the disk, and the file is
+// periodically rewritten to drop records that have been replaced. The entire store is
+// loaded into memory when it is opened, so reads never touch the disk.
+//
+// If the daemon crashes while a record is being written that record fails its checksum
+// when the store is next opened, and it is discarded along with anything after it.
+type KVStore struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	data map[string][]byte
+
+	// The size of the file on the disk, and the number of bytes in it used by records that
+	// have not been replaced.
+	size int64
+	live int64
+}
+
+var _ Store = (*KVStore)(nil)
+
+// Opens the key-value store in the given directory, creating it if it does not exist.
+func NewKVStore(root string) (*KVStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	kv := &KVStore{
+		path: filepath.Join(root, "servers.db"),
+		data: make(map[string][]byte),
+	}
+
+	if err := kv.load(); err != nil {
+		return nil, err
+	}
+
+	return kv, nil
+}
+
+// Reads all of the records from the file into memory and opens it for appending.
+func (kv *KVStore) load() error {
+	b, err := ioutil.ReadFile(kv.path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	if len(b) == 0 {
+		if err := atomicfile.WriteFile(kv.path, []byte(kvHeader), 0600); err != nil {
+			return err
+		}
+
+		b = []byte(kvHeader)
+	}
+
+	if !bytes.HasPrefix(b, []byte(kvHeader)) {
+		return errors.Errorf("%s is not a server store", kv.path)
+	}
+
+	offset := int64(len(kvHeader))
+	for offset < int64(len(b)) {
+		op, key, value, n, ok := decodeKVRecord(b[offset:])
+		if !ok {
+			break
+		}
+
+		kv.apply(op, key, value, n)
+		offset += n
+	}
+
+	f, err := os.OpenFile(kv.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Anything after the last valid record was left behind by a write that did not complete
+	// and is removed so that new records are not appended after it.
+	if offset < int64(len(b)) {
+		zap.S().Warnw("discarding incomplete records from the end of the server store", zap.String("path", kv.path), zap.Int64("bytes", int64(len(b))-offset))
+
+		if err := f.Truncate(offset); err != nil {
+			f.Close()
+
+			return errors.WithStack(err)
+		}
+	}
+
+	kv.f = f
+	kv.size = offset
+
+	return nil
+}
+
+// Applies a record to the in-memory copy of the store, n being the size of the record.
+func (kv *KVStore) apply(op byte, key string, value []byte, n int64) {
+	if old, ok := kv.data[key]; ok {
+		kv.live -= int64(kvRecordHeaderSize + len(key) + len(old))
+	}
+
+	if op == kvOpDelete {
+		delete(kv.data, key)
+		return
+	}
+
+	kv.data[key] = value
+	kv.live += n
+}
+
+// Appends a record to the file and syncs it to the disk before applying it in memory.
+func (kv *KVStore) write(op byte, key string, value []byte) error {
+	r := encodeKVRecord(op, key, value)
+	if _, err := kv.f.Write(r); err != nil {
+		// Remove anything that was partially written so that later records are not
+		// appended after it, which would cause them to be discarded when loading.
+		kv.f.Truncate(kv.size)
+
+		return errors.WithStack(err)
+	}
+
+	if err := kv.f.Sync(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	kv.size += int64(len(r))
+	kv.apply(op, key, value, int64(len(r)))
+
+	if kv.size > kvCompactThreshold && kv.size > kv.live*2 {
+		if err := kv.compact(); err != nil {
+			zap.S().Warnw("failed to compact the server store", zap.String("path", kv.path), zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// Rewrites the file so that it only contains the records that are still in use.
+func (kv *KVStore) compact() error {
+	b := bytes.NewBufferString(kvHeader)
+	for k, v := range kv.data {
+		b.Write(encodeKVRecord(kvOpPut, k, v))
+	}
+
+	if err := atomicfile.WriteFile(kv.path, b.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(kv.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	kv.f.Close()
+	kv.f = f
+	kv.size = int64(b.Len())
+
+	return nil
+}
+
+func (kv *KVStore) List() ([]string, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	uuids := make([]string, 0, len(kv.data))
+	for k := range kv.data {
+		uuids = append(uuids, k)
+	}
+	sort.Strings(uuids)
+
+	return uuids, nil
+}
+
+func (kv *KVStore) Get(uuid string) ([]byte, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	v, ok := kv.data[uuid]
+	if !ok {
+		return nil, &storeNotFound{uuid: uuid}
+	}
+
+	return append([]byte(nil), v...), nil
+}
+
+func (kv *KVStore) Put(uuid string, data []byte) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	return kv.write(kvOpPut, uuid, append([]byte(nil), data...))
+}
+
+func (kv *KVStore) Delete(uuid string) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	if _, ok := kv.data[uuid]; !ok {
+		return nil
+	}
+
+	return kv.write(kvOpDelete, uuid, nil)
+}
+
+func (kv *KVStore) Close() error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	return errors.WithStack(kv.f.Close())
+}
+
+// Encodes a record as a checksum followed by the operation, the lengths of the key and
+// value, and then the key and value themselves.
+func encodeKVRecord(op byte, key string, value []byte) []byte {
+	b := make([]byte, kvRecordHeaderSize+len(key)+len(value))
+
+	b[4] = op
+	binary.BigEndian.PutUint32(b[5:], uint32(len(key)))
+	binary.BigEndian.PutUint32(b[9:], uint32(len(value)))
+	copy(b[kvRecordHeaderSize:], key)
+	copy(b[kvRecordHeaderSize+len(key):], value)
+
+	binary.BigEndian.PutUint32(b, crc32.ChecksumIEEE(b[4:]))
+
+	return b
+}
+
+// Decodes the record at the start of b, returning false if the record is incomplete or
+// does not match its checksum.
+func decodeKVRecord(b []byte) (op byte, key string, value []byte, n int64, ok bool) {
+	if len(b) < kvRecordHeaderSize {
+		return
+	}
+
+	kl := int64(binary.BigEndian.Uint32(b[5:]))
+	vl := int64(binary.BigEndian.Uint32(b[9:]))
+
+	n = kvRecordHeaderSize + kl + vl
+	if int64(len(b)) < n || binary.BigEndian.Uint32(b) != crc32.ChecksumIEEE(b[4:n]) {
+		return 0, "", nil, 0, false
+	}
+
+	op = b[4]
+	if op != kvOpPut && op != kvOpDelete {
+		return 0, "", nil, 0, false
+	}
+
+	key = string(b[kvRecordHeaderSize : kvRecordHeaderSize+kl])
+	value = append([]byte(nil), b[kvRecordHeaderSize+kl:n]...)
+
+	return op, key, value, n, true
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Opens a key-value store in a new temporary directory, returning the directory so that
+// the store can be reopened. The directory must be removed by the caller.
+func openTestKVStore(t *testing.T) (*KVStore, string) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "wings-kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err := NewKVStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return kv, dir
+}
+
+func reopenTestKVStore(t *testing.T, kv *KVStore, dir string) *KVStore {
+	t.Helper()
+
+	if err := kv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err := NewKVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return kv
+}
+
+func expectKVValue(t *testing.T, kv *KVStore, uuid string, expected []byte) {
+	t.Helper()
+
+	b, err := kv.Get(uuid)
+	if err != nil {
+		t.Fatalf("expected %s to be in the store: %s", uuid, err)
+	}
+
+	if !bytes.Equal(b, expected) {
+		t.Fatalf("expected %s to be %q, got %q", uuid, expected, b)
+	}
+}
+
+func expectKVKeys(t *testing.T, kv *KVStore, expected ...string) {
+	t.Helper()
+
+	uuids, err := kv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uuids) == 0 && len(expected) == 0 {
+		return
+	}
+
+	if !reflect.DeepEqual(uuids, expected) {
+		t.Fatalf("expected the store to contain %v, got %v", expected, uuids)
+	}
+}
+
+func TestKVStoreRoundTrip(t *testing.T) {
+	kv, dir := openTestKVStore(t)
+	defer os.RemoveAll(dir)
+
+	if err := kv.Put("b", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Put("a", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Put("a", []byte("replaced")); err != nil {
+		t.Fatal(err)
+	}
+
+	expectKVKeys(t, kv, "a", "b")
+	expectKVValue(t, kv, "a", []byte("replaced"))
+
+	kv = reopenTestKVStore(t, kv, dir)
+	defer kv.Close()
+
+	expectKVKeys(t, kv, "a", "b")
+	expectKVValue(t, kv, "a", []byte("replaced"))
+	expectKVValue(t, kv, "b", []byte("second"))
+
+	if _, err := kv.Get("c"); !IsStoreNotFoundError(err) {
+		t.Fatalf("expected a not found error for a missing server, got %v", err)
+	}
+}
+
+func TestKVStoreDelete(t *testing.T) {
+	kv, dir := openTestKVStore(t)
+	defer os.RemoveAll(dir)
+
+	if err := kv.Put("a", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Put("b", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Delete("missing"); err != nil {
+		t.Fatalf("expected deleting a missing server to succeed, got %s", err)
+	}
+
+	kv = reopenTestKVStore(t, kv, dir)
+	defer kv.Close()
+
+	expectKVKeys(t, kv, "b")
+	if _, err := kv.Get("a"); !IsStoreNotFoundError(err) {
+		t.Fatalf("expected the deleted server to be missing, got %v", err)
+	}
+}
+
+func TestKVStoreDiscardsDamagedTail(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(b []byte) []byte
+		// The servers expected to still be in the store once it is reopened.
+		expected []string
+	}{
+		{
+			name: "torn record",
+			damage: func(b []byte) []byte {
+				return b[:len(b)-3]
+			},
+			expected: []string{"a"},
+		},
+		{
+			name: "corrupt record",
+			damage: func(b []byte) []byte {
+				b[len(b)-1] ^= 0xff
+				return b
+			},
+			expected: []string{"a"},
+		},
+		{
+			name: "partial record header",
+			damage: func(b []byte) []byte {
+				return append(b, 0, 0, 0)
+			},
+			expected: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kv, dir := openTestKVStore(t)
+			defer os.RemoveAll(dir)
+
+			if err := kv.Put("a", []byte("first")); err != nil {
+				t.Fatal(err)
+			}
+			if err := kv.Put("b", []byte("second")); err != nil {
+				t.Fatal(err)
+			}
+			kv.Close()
+
+			p := filepath.Join(dir, "servers.db")
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(p, tt.damage(b), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			kv, err = NewKVStore(dir)
+			if err != nil {
+				t.Fatalf("expected the store to open, got %s", err)
+			}
+
+			expectKVKeys(t, kv, tt.expected...)
+			expectKVValue(t, kv, "a", []byte("first"))
+
+			// Records written after the damaged tail has been removed must not be lost
+			// the next time the store is opened.
+			if err := kv.Put("c", []byte("third")); err != nil {
+				t.Fatal(err)
+			}
+
+			kv = reopenTestKVStore(t, kv, dir)
+			defer kv.Close()
+
+			expectKVKeys(t, kv, append(tt.expected, "c")...)
+			expectKVValue(t, kv, "c", []byte("third"))
+		})
+	}
+}
+
+func TestKVStoreCompaction(t *testing.T) {
+	kv, dir := openTestKVStore(t)
+	defer os.RemoveAll(dir)
+
+	value := bytes.Repeat([]byte("x"), 64*1024)
+
+	// Replacing the same few servers over and over leaves the file mostly made up of
+	// records that are no longer used, so it is compacted once it passes the threshold.
+	for i := 0; i < 40; i++ {
+		for _, k := range []string{"a", "b"} {
+			if err := kv.Put(k, append(value, fmt.Sprintf("%s-%d", k, i)...)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := kv.Put("c", []byte("third")); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := os.Stat(filepath.Join(dir, "servers.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Size() > kvCompactThreshold {
+		t.Fatalf("expected the store to be compacted, it is %d bytes", st.Size())
+	}
+
+	if kv.size != st.Size() {
+		t.Fatalf("expected the tracked size to match the %d byte file, got %d", st.Size(), kv.size)
+	}
+
+	expectKVValue(t, kv, "a", append(value, "a-39"...))
+
+	// The file is replaced when it is compacted, so the store must keep appending to the
+	// new file rather than the one it originally opened.
+	if err := kv.Put("d", []byte("fourth")); err != nil {
+		t.Fatal(err)
+	}
+
+	kv = reopenTestKVStore(t, kv, dir)
+	defer kv.Close()
+
+	expectKVKeys(t, kv, "a", "b", "c", "d")
+	expectKVValue(t, kv, "a", append(value, "a-39"...))
+	expectKVValue(t, kv, "b", append(value, "b-39"...))
+	expectKVValue(t, kv, "c", []byte("third"))
+	expectKVValue(t, kv, "d", []byte("fourth"))
+}
+
+func TestKVStoreRejectsOtherFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wings-kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "servers.db"), []byte("not a store"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewKVStore(dir); err == nil {
+		t.Fatal("expected a file without the store header to be rejected")
+	}
+}
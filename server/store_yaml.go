@@ -0,0 +1,80 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/atomicfile"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stores each server definition in its own YAML file named after the server's UUID. This
+// is the layout that has always been used by the daemon.
+type YamlStore struct {
+	root string
+}
+
+var _ Store = (*YamlStore)(nil)
+
+// Creates a store that keeps server definitions as YAML files in the given directory.
+func NewYamlStore(root string) (*YamlStore, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &YamlStore{root: root}, nil
+}
+
+func (ys *YamlStore) path(uuid string) string {
+	return filepath.Join(ys.root, uuid+".yml")
+}
+
+func (ys *YamlStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(ys.root)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var uuids []string
+	for _, f := range files {
+		// Skip over hidden files, which includes any temporary files left behind by a
+		// write that did not complete.
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !strings.HasSuffix(f.Name(), ".yml") {
+			continue
+		}
+
+		uuids = append(uuids, strings.TrimSuffix(f.Name(), ".yml"))
+	}
+
+	return uuids, nil
+}
+
+func (ys *YamlStore) Get(uuid string) ([]byte, error) {
+	b, err := ioutil.ReadFile(ys.path(uuid))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &storeNotFound{uuid: uuid}
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	return b, nil
+}
+
+func (ys *YamlStore) Put(uuid string, data []byte) error {
+	return atomicfile.WriteFile(ys.path(uuid), data, 0644)
+}
+
+func (ys *YamlStore) Delete(uuid string) error {
+	if err := os.Remove(ys.path(uuid)); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (ys *YamlStore) Close() error {
+	return nil
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+var _taskRunner func(fn func(ctx context.Context))
+var _taskRunnerMutex sync.Mutex
+
+// Sets the function used to run the power actions that servers start on their own, such as
+// restarting a server after it crashes. This allows the daemon to wait for those actions
+// to complete when it is shutting down, and to cancel them through the context passed to
+// them if they do not.
+func SetTaskRunner(r func(fn func(ctx context.Context))) {
+	_taskRunnerMutex.Lock()
+	defer _taskRunnerMutex.Unlock()
+
+	_taskRunner = r
+}
+
+// Runs a background power action using the task runner set by the daemon, or in a new
+// goroutine if no runner has been set.
+func runTask(fn func(ctx context.Context)) {
+	_taskRunnerMutex.Lock()
+	r := _taskRunner
+	_taskRunnerMutex.Unlock()
+
+	if r == nil {
+		go fn(context.Background())
+
+		return
+	}
+
+	r(fn)
+}
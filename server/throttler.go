@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// The outcome of checking a line of console output against the throttle for a server.
+type throttleResult int
+
+const (
+	// The output is within the limits and should be published.
+	throttleAllow throttleResult = iota
+
+	// The server has already been warned for exceeding the limit during this interval, the
+	// output should be discarded.
+	throttleDrop
+
+	// The output pushed the server over the limit for this interval and a warning should
+	// be issued. The output should be discarded.
+	throttleWarn
+
+	// The server has accumulated enough warnings that it should be stopped. All output is
+	// discarded until the throttle is reset.
+	throttleKill
+)
+
+// Limits the rate at which a server can write output to its console, using the throttle
+// settings from the configuration. Every interval the server is allowed to output a fixed
+// number of bytes, and anything beyond that is discarded for the rest of the interval and
+// counts as a warning against the server. Warnings decay over time, but a server that
+// accumulates too many of them is stopped.
+type ConsoleThrottler struct {
+	mu sync.Mutex
+
+	// The start of the current interval and the number of bytes output during it, and
+	// whether the server has exceeded the limit in it.
+	intervalStart time.Time
+	bytes         int
+	throttled     bool
+
+	// The number of warnings the server has, and the time that the count last changed.
+	warnings    int
+	lastWarning time.Time
+
+	// Set once the server has been stopped for exceeding the warning threshold.
+	killed bool
+}
+
+// Clears the state of the throttle. This is done each time the server process is started
+// so that warnings from the previous run do not carry over.
+func (t *ConsoleThrottler) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.intervalStart = time.Time{}
+	t.bytes = 0
+	t.throttled = false
+	t.warnings = 0
+	t.lastWarning = time.Time{}
+	t.killed = false
+}
+
+// Records n bytes of console output at the given time, and returns what should be done
+// with the output.
+func (t *ConsoleThrottler) Check(n int, now time.Time) throttleResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.killed {
+		return throttleDrop
+	}
+
+	cfg := config.Get().Throttles
+
+	// Configuration files written before the default for the interval was being applied
+	// have a zero value for it, so treat that as the default.
+	interval := time.Millisecond * time.Duration(cfg.CheckInterval)
+	if interval <= 0 {
+		interval = time.Millisecond * 100
+	}
+
+	if now.Sub(t.intervalStart) >= interval {
+		t.intervalStart = now
+		t.bytes = 0
+		t.throttled = false
+	}
+
+	// Remove a warning for each full decay period that has passed since the count last
+	// changed.
+	decay := time.Second * time.Duration(cfg.DecaySeconds)
+	if t.warnings > 0 && decay > 0 {
+		if steps := int(now.Sub(t.lastWarning) / decay); steps > 0 {
+			t.warnings -= steps
+			if t.warnings < 0 {
+				t.warnings = 0
+			}
+
+			t.lastWarning = t.lastWarning.Add(decay * time.Duration(steps))
+		}
+	}
+
+	t.bytes += n
+	if t.throttled {
+		return throttleDrop
+	}
+
+	if t.bytes <= cfg.BytesPerInterval {
+		return throttleAllow
+	}
+
+	t.throttled = true
+	t.warnings++
+	t.lastWarning = now
+
+	if t.warnings >= cfg.KillAtCount {
+		t.killed = true
+
+		return throttleKill
+	}
+
+	return throttleWarn
+}
+
+// Checks a line of console output against the throttle for the server, returning false
+// if it should be discarded rather than published. Warnings are sent to the console of
+// the server, and the server is stopped if it exceeds the warning threshold.
+func (s *Server) throttleConsoleOutput(line []byte) bool {
+	switch s.throttler.Check(len(line), time.Now()) {
+	case throttleAllow:
+		return true
+	case throttleWarn:
+		s.Events().Publish(DaemonMessageEvent, "Server is outputting console data too quickly -- throttling...")
+	case throttleKill:
+		zap.S().Warnw("stopping server for exceeding the console output throttle", zap.String("server", s.Uuid))
+
+		s.Events().Publish(DaemonMessageEvent, "Server is outputting console data too quickly and is being stopped.")
+
+		runTask(func(context.Context) {
+			s.stopForThrottle()
+		})
+	}
+
+	return false
+}
+
+// Stops the server after it has exceeded the console output throttle. The server moves
+// through the stopping state, so it is not treated as having crashed and will not be
+// restarted automatically.
+func (s *Server) stopForThrottle() {
+	if err := s.AcquirePowerLock(true); err != nil {
+		zap.S().Errorw("failed to acquire power lock to stop throttled server", zap.String("server", s.Uuid), zap.Error(err))
+		return
+	}
+	defer s.ReleasePowerLock()
+
+	if err := s.Environment.WaitForStop(context.Background(), time.Second*time.Duration(config.Get().System.StopTimeout), true); err != nil {
+		zap.S().Errorw("failed to stop throttled server", zap.String("server", s.Uuid), zap.Error(err))
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"github.com/creasty/defaults"
+	"github.com/pterodactyl/wings/config"
+	"testing"
+	"time"
+)
+
+func TestConsoleThrottlerCheck(t *testing.T) {
+	c := new(config.Configuration)
+	if err := defaults.Set(c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Throttles.BytesPerInterval = 10
+	c.Throttles.CheckInterval = 100
+	c.Throttles.DecaySeconds = 1
+	c.Throttles.KillAtCount = 3
+
+	previous := config.Get()
+	config.Set(c)
+	defer config.Set(previous)
+
+	type step struct {
+		// The time of the output, relative to the first step.
+		at       time.Duration
+		bytes    int
+		expected throttleResult
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "output within the limit is allowed",
+			steps: []step{
+				{0, 5, throttleAllow},
+				{time.Millisecond * 10, 5, throttleAllow},
+				{time.Millisecond * 100, 10, throttleAllow},
+			},
+		},
+		{
+			name: "crossing the threshold warns and drops output for the interval",
+			steps: []step{
+				{0, 8, throttleAllow},
+				{time.Millisecond * 10, 3, throttleWarn},
+				{time.Millisecond * 20, 1, throttleDrop},
+				{time.Millisecond * 100, 1, throttleAllow},
+			},
+		},
+		{
+			name: "warnings decay after the decay period",
+			steps: []step{
+				{0, 11, throttleWarn},
+				{time.Millisecond * 100, 11, throttleWarn},
+				// One warning has decayed, so this is only the second.
+				{time.Millisecond * 1200, 11, throttleWarn},
+				{time.Millisecond * 1300, 11, throttleKill},
+			},
+		},
+		{
+			name: "warnings fully decay after several periods",
+			steps: []step{
+				{0, 11, throttleWarn},
+				{time.Millisecond * 100, 11, throttleWarn},
+				{time.Second * 5, 11, throttleWarn},
+				{time.Millisecond * 5100, 11, throttleWarn},
+				{time.Millisecond * 5200, 11, throttleKill},
+			},
+		},
+		{
+			name: "reaching the kill count stops the server and drops all output",
+			steps: []step{
+				{0, 11, throttleWarn},
+				{time.Millisecond * 100, 11, throttleWarn},
+				{time.Millisecond * 200, 11, throttleKill},
+				{time.Millisecond * 300, 1, throttleDrop},
+				{time.Second * 10, 1, throttleDrop},
+			},
+		},
+	}
+
+	start := time.Now()
+	for _, tt := range tests {
+		var throttler ConsoleThrottler
+
+		for i, s := range tt.steps {
+			if actual := throttler.Check(s.bytes, start.Add(s.at)); actual != s.expected {
+				t.Errorf("%s: expected step %d to return %d, got %d", tt.name, i, s.expected, actual)
+				break
+			}
+		}
+	}
+}
+
+func TestConsoleThrottlerReset(t *testing.T) {
+	c := new(config.Configuration)
+	if err := defaults.Set(c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Throttles.BytesPerInterval = 10
+	c.Throttles.KillAtCount = 1
+
+	previous := config.Get()
+	config.Set(c)
+	defer config.Set(previous)
+
+	var throttler ConsoleThrottler
+
+	now := time.Now()
+	if r := throttler.Check(11, now); r != throttleKill {
+		t.Fatalf("expected the server to be killed, got %d", r)
+	}
+
+	throttler.Reset()
+
+	if r := throttler.Check(1, now); r != throttleAllow {
+		t.Fatalf("expected output to be allowed after resetting the throttle, got %d", r)
+	}
+}
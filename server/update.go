@@ -1,12 +1,15 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/buger/jsonparser"
 	"github.com/imdario/mergo"
 	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
 	"go.uber.org/zap"
 	"os"
+	"time"
 )
 
 // Merges data passed through in JSON form into the existing server object.
@@ -29,8 +32,30 @@ func (s *Server) UpdateDataStructure(data []byte, background bool) error {
 		return errors.New("attempting to merge a data stack with an invalid UUID")
 	}
 
-	// Merge the new data object that we have received with the existing server data object
-	// and then save it to the disk so it is persistent.
+	// The configuration of the server may be being written to the disk in the background,
+	// such as after a change in state, so hold the same lock while it is modified.
+	s.mutex.Lock()
+	if err := s.mergeDataStructure(data, src); err != nil {
+		s.mutex.Unlock()
+
+		return err
+	}
+	s.mutex.Unlock()
+
+	if _, err := s.WriteConfigurationToDisk(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if background {
+		s.runBackgroundActions()
+	}
+
+	return nil
+}
+
+// Merges the new data object that was received into the existing server data object. This
+// must be called while holding the server mutex.
+func (s *Server) mergeDataStructure(data []byte, src *Server) error {
 	if err := mergo.Merge(s, src, mergo.WithOverride); err != nil {
 		return errors.WithStack(err)
 	}
@@ -65,14 +90,6 @@ func (s *Server) UpdateDataStructure(data []byte, background bool) error {
 		s.Allocations.Mappings = src.Allocations.Mappings
 	}
 
-	if _, err := s.WriteConfigurationToDisk(); err != nil {
-		return errors.WithStack(err)
-	}
-
-	if background {
-		s.runBackgroundActions()
-	}
-
 	return nil
 }
 
@@ -86,7 +103,7 @@ func (s *Server) runBackgroundActions() {
 	// Update the environment in place, allowing memory and CPU usage to be adjusted
 	// on the fly without the user needing to reboot (theoretically).
 	go func(server *Server) {
-		if err := server.Environment.InSituUpdate(); err != nil {
+		if err := server.Environment.InSituUpdate(context.Background()); err != nil {
 			zap.S().Warnw(
 				"failed to perform in-situ update of server environment",
 				zap.String("server", server.Uuid),
@@ -96,18 +113,47 @@ func (s *Server) runBackgroundActions() {
 	}(s)
 
 	// Check if the server is now suspended, and if so and the process is not terminated
-	// yet, do it immediately.
-	go func(server *Server) {
-		if server.Suspended && server.State != ProcessOfflineState {
-			zap.S().Infow("server suspended with running process state, terminating now", zap.String("server", server.Uuid))
+	// yet, do it immediately. Servers that are no longer suspended are moved back into
+	// the offline state so that they can be started again.
+	runTask(func(context.Context) {
+		if !s.Suspended {
+			if s.GetState() == ProcessSuspendedState {
+				s.setStateOrWarn(ProcessOfflineState, "server unsuspended")
+			}
+
+			return
+		}
+
+		switch s.GetState() {
+		case ProcessOfflineState:
+			s.setStateOrWarn(ProcessSuspendedState, "server suspended")
+		case ProcessStartingState, ProcessRunningState, ProcessStoppingState:
+			zap.S().Infow("server suspended with running process state, terminating now", zap.String("server", s.Uuid))
 
-			if err := server.Environment.Terminate(os.Kill); err != nil {
+			ctx := context.Background()
+			if err := s.Environment.Terminate(ctx, os.Kill); err != nil {
 				zap.S().Warnw(
 					"failed to terminate server environment after seeing suspension",
-					zap.String("server", server.Uuid),
+					zap.String("server", s.Uuid),
 					zap.Error(err),
 				)
+
+				return
 			}
+
+			// Wait for the process to exit and the server to be marked as offline before
+			// moving it into the suspended state.
+			if err := s.Environment.WaitForStop(ctx, time.Second*time.Duration(config.Get().System.StopTimeout), true); err != nil {
+				zap.S().Warnw(
+					"failed to wait for server process to stop after seeing suspension",
+					zap.String("server", s.Uuid),
+					zap.Error(err),
+				)
+
+				return
+			}
+
+			s.setStateOrWarn(ProcessSuspendedState, "server suspended")
 		}
-	}(s)
+	})
 }
@@ -1,28 +1,52 @@
 package sftp
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/sftp-server"
 	"github.com/pterodactyl/wings/api"
 	"github.com/pterodactyl/wings/config"
 	"github.com/pterodactyl/wings/server"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"net"
+	"os"
 	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
+// Set once the daemon begins shutting down, after which no new SFTP sessions are allowed.
+var shuttingDown int32
+
+// The listener that SFTP connections are accepted on. The SFTP server package creates a
+// listener that it never closes, so the daemon runs the accept loop itself instead.
+var listener struct {
+	sync.Mutex
+	l net.Listener
+}
+
 func Initialize(config *config.Configuration) error {
 	c := &sftp_server.Server{
 		User: sftp_server.SftpUser{
 			Uid: config.System.User.Uid,
 			Gid: config.System.User.Gid,
 		},
+		// Disk checking is not configured here, it is handled by the disk space validator
+		// so that changes to it apply when the configuration is reloaded.
 		Settings: sftp_server.Settings{
 			BasePath:         config.System.Data,
 			ReadOnly:         config.System.Sftp.ReadOnly,
 			BindAddress:      config.System.Sftp.Address,
 			BindPort:         config.System.Sftp.Port,
 			ServerDataFolder: path.Join(config.System.Data, "/servers"),
-			DisableDiskCheck: config.System.Sftp.DisableDiskChecking,
 		},
 		CredentialValidator: validateCredentials,
 		PathValidator: validatePath,
@@ -40,7 +64,7 @@ func Initialize(config *config.Configuration) error {
 	// Initialize the SFTP server in a background thread since this is
 	// a long running operation.
 	go func(instance *sftp_server.Server) {
-		if err := c.Initalize(); err != nil {
+		if err := listen(instance); err != nil {
 			zap.S().Named("sftp").Errorw("failed to initialize SFTP subsystem", zap.Error(errors.WithStack(err)))
 		}
 	}(c)
@@ -48,6 +72,122 @@ func Initialize(config *config.Configuration) error {
 	return nil
 }
 
+// Listens for SFTP connections and hands each one off to the SFTP server until the listener
+// is closed by Shutdown.
+func listen(c *sftp_server.Server) error {
+	serverConfig := &ssh.ServerConfig{
+		NoClientAuth: false,
+		MaxAuthTries: 6,
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			resp, err := c.CredentialValidator(sftp_server.AuthenticationRequest{
+				User: conn.User(),
+				Pass: string(pass),
+			})
+
+			if err != nil {
+				if !sftp_server.IsInvalidCredentialsError(err) {
+					zap.S().Named("sftp").Errorw("encountered error validating user crendentials", zap.Error(err))
+				}
+
+				return nil, err
+			}
+
+			return &ssh.Permissions{
+				Extensions: map[string]string{
+					"uuid":        resp.Server,
+					"user":        conn.User(),
+					"permissions": strings.Join(resp.Permissions, ","),
+				},
+			}, nil
+		},
+	}
+
+	key, err := loadPrivateKey(c.Settings.BasePath)
+	if err != nil {
+		return err
+	}
+
+	serverConfig.AddHostKey(key)
+
+	l, err := net.Listen("tcp", fmt.Sprintf("%s:%d", c.Settings.BindAddress, c.Settings.BindPort))
+	if err != nil {
+		return err
+	}
+
+	// The daemon may have started shutting down while the key was being loaded.
+	listener.Lock()
+	if atomic.LoadInt32(&shuttingDown) == 1 {
+		listener.Unlock()
+
+		return l.Close()
+	}
+	listener.l = l
+	listener.Unlock()
+
+	zap.S().Named("sftp").Infow("sftp subsystem listening for connections", zap.String("host", c.Settings.BindAddress), zap.Int("port", c.Settings.BindPort))
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if atomic.LoadInt32(&shuttingDown) == 1 {
+				return nil
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+
+			return err
+		}
+
+		go c.AcceptInboundConnection(conn, serverConfig)
+	}
+}
+
+// Reads the private key used as the host key for the SFTP server, generating one if it does
+// not exist yet. This uses the same location as the SFTP server package.
+func loadPrivateKey(base string) (ssh.Signer, error) {
+	p := path.Join(base, ".sftp/id_rsa")
+
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		if err := generatePrivateKey(p); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.ParsePrivateKey(b)
+}
+
+// Generates a new private key for the SFTP server and writes it to the given path.
+func generatePrivateKey(p string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return pem.Encode(f, &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+}
+
 func validatePath(fs sftp_server.FileSystem, p string) (string, error) {
 	s := server.GetServers().Find(func(server *server.Server) bool {
 		return server.Uuid == fs.UUID
@@ -61,6 +201,10 @@ func validatePath(fs sftp_server.FileSystem, p string) (string, error) {
 }
 
 func validateDiskSpace(fs sftp_server.FileSystem) bool {
+	if config.Get().System.Sftp.DisableDiskChecking {
+		return true
+	}
+
 	s := server.GetServers().Find(func(server *server.Server) bool {
 		return server.Uuid == fs.UUID
 	})
@@ -75,7 +219,11 @@ func validateDiskSpace(fs sftp_server.FileSystem) bool {
 // Validates a set of credentials for a SFTP login aganist Pterodactyl Panel and returns
 // the server's UUID if the credentials were valid.
 func validateCredentials(c sftp_server.AuthenticationRequest) (*sftp_server.AuthenticationResponse, error) {
-	resp, err := api.NewRequester().ValidateSftpCredentials(c)
+	if atomic.LoadInt32(&shuttingDown) == 1 {
+		return nil, errors.New("daemon is shutting down")
+	}
+
+	resp, err := api.NewRequester().ValidateSftpCredentials(context.Background(), c)
 	if err != nil {
 		return resp, err
 	}
@@ -90,3 +238,21 @@ func validateCredentials(c sftp_server.AuthenticationRequest) (*sftp_server.Auth
 
 	return resp, err
 }
+
+// Stops the SFTP subsystem from accepting any new sessions by closing the listener, and
+// rejects any login attempts on connections that were accepted before it was closed.
+// Sessions that are already open are not affected.
+func Shutdown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+
+	listener.Lock()
+	defer listener.Unlock()
+
+	if listener.l != nil {
+		if err := listener.l.Close(); err != nil {
+			zap.S().Named("sftp").Warnw("failed to close SFTP listener", zap.Error(err))
+		}
+
+		listener.l = nil
+	}
+}
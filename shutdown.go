@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/sftp"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+// The message returned when a request is refused because the daemon is shutting down.
+const errShuttingDown = "the daemon is shutting down"
+
+// Runs a function in a background thread that is tracked by the router, allowing shutdown
+// to wait for it to complete. This should be used for any long running work started by a
+// request that must not be abandoned half way through, such as installing a server.
+//
+// Returns false without running the function if the daemon has started waiting for tasks
+// to complete because it is shutting down.
+func (rt *Router) runTask(fn func()) bool {
+	rt.tasksMutex.Lock()
+	defer rt.tasksMutex.Unlock()
+
+	if rt.tasksClosed {
+		return false
+	}
+
+	rt.tasks.Add(1)
+
+	go func() {
+		defer rt.tasks.Done()
+
+		fn()
+	}()
+
+	return true
+}
+
+// Runs a power action that a server started on its own, such as restarting after a crash,
+// as a tracked background task. The context passed to the action is cancelled if it has
+// not completed in time when the daemon is shutting down.
+func (rt *Router) runServerTask(fn func(ctx context.Context)) {
+	ok := rt.runTask(func() {
+		fn(rt.ctx)
+	})
+
+	if !ok {
+		zap.S().Warnw("not running server task since the daemon is shutting down")
+	}
+}
+
+// Registers an open websocket connection so that it can be closed when the daemon shuts
+// down. Returns false if the daemon is already shutting down, in which case the connection
+// should be closed right away.
+func (rt *Router) trackWebsocket(h *WebsocketHandler) bool {
+	rt.socketsMutex.Lock()
+	defer rt.socketsMutex.Unlock()
+
+	if rt.closing {
+		return false
+	}
+
+	rt.sockets[h] = struct{}{}
+
+	return true
+}
+
+// Determines if the router has stopped accepting websocket connections.
+func (rt *Router) isClosing() bool {
+	rt.socketsMutex.Lock()
+	defer rt.socketsMutex.Unlock()
+
+	return rt.closing
+}
+
+func (rt *Router) untrackWebsocket(h *WebsocketHandler) {
+	rt.socketsMutex.Lock()
+	defer rt.socketsMutex.Unlock()
+
+	delete(rt.sockets, h)
+}
+
+// Closes all of the open websocket connections using a service restart close code so that
+// clients know to reconnect once the daemon is back, and rejects any new connections.
+func (rt *Router) closeWebsockets() {
+	rt.socketsMutex.Lock()
+	defer rt.socketsMutex.Unlock()
+
+	rt.closing = true
+
+	msg := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "daemon is shutting down")
+	for h := range rt.sockets {
+		h.Connection.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second*5))
+		h.Connection.Close()
+	}
+}
+
+// Blocks until all of the tracked background tasks have completed, or until the context
+// is done. Returns false if tasks were still running when the context finished. No new
+// tasks are accepted once this has been called.
+func (rt *Router) waitForTasks(ctx context.Context) bool {
+	rt.tasksMutex.Lock()
+	rt.tasksClosed = true
+	rt.tasksMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		rt.tasks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Gracefully shuts the daemon down. This stops accepting new connections, closes the open
+// websockets, waits for requests and background tasks to finish, and then writes the state
+// of every server to the disk.
+//
+// Server processes are intentionally left running, the daemon will attach to them again
+// when it is next started.
+func shutdown(srv *http.Server, rt *Router) {
+	timeout := time.Second * time.Duration(config.Get().System.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	sftp.Shutdown()
+	rt.closeWebsockets()
+
+	zap.S().Infow("waiting for in-flight requests to complete", zap.Duration("timeout", timeout))
+	if err := srv.Shutdown(ctx); err != nil {
+		zap.S().Warnw("failed to gracefully shutdown webserver", zap.Error(err))
+	}
+
+	if !rt.waitForTasks(ctx) {
+		// Cancel anything that is still running and give it a short amount of time to clean
+		// up after itself, for example by removing an installation container.
+		zap.S().Warnw("background tasks did not complete in time, cancelling them")
+		rt.cancel()
+
+		cctx, ccancel := context.WithTimeout(context.Background(), time.Second*10)
+		defer ccancel()
+
+		if !rt.waitForTasks(cctx) {
+			zap.S().Warnw("background tasks did not stop after being cancelled, exiting anyways")
+		}
+	}
+
+	// Writing the configuration obtains the same lock used by any other writes that are
+	// in progress, so this also waits for those to complete.
+	for _, s := range server.GetServers().All() {
+		if _, err := s.WriteConfigurationToDisk(); err != nil {
+			zap.S().Errorw("failed to write server configuration to disk", zap.String("server", s.Uuid), zap.Error(err))
+		}
+	}
+
+	if err := server.GetStore().Close(); err != nil {
+		zap.S().Errorw("failed to close server store", zap.Error(err))
+	}
+
+	zap.S().Infow("shutdown complete")
+}
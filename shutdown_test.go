@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"github.com/gorilla/websocket"
+	"testing"
+	"time"
+)
+
+func TestRouterWaitsForTasks(t *testing.T) {
+	rt := NewRouter(websocket.Upgrader{})
+
+	release := make(chan struct{})
+	if !rt.runTask(func() { <-release }) {
+		t.Fatal("expected the task to be accepted")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	if rt.waitForTasks(ctx) {
+		t.Fatal("expected waiting to time out while the task is running")
+	}
+
+	close(release)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if !rt.waitForTasks(ctx) {
+		t.Fatal("expected the task to complete")
+	}
+}
+
+func TestRouterRefusesTasksOnceShuttingDown(t *testing.T) {
+	rt := NewRouter(websocket.Upgrader{})
+
+	// Tasks being started while the router is waiting for them must either be waited on
+	// or refused, running this with the race detector catches them being added to the
+	// wait group while it is being waited on.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for i := 0; i < 100; i++ {
+			rt.runTask(func() {})
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	if !rt.waitForTasks(ctx) {
+		t.Fatal("expected the tasks to complete")
+	}
+	<-done
+
+	if rt.runTask(func() { t.Error("expected the task to not be run") }) {
+		t.Fatal("expected the task to be refused once shutdown has started")
+	}
+}
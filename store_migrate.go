@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"os"
+)
+
+// Handles the "store" subcommands, which operate on the server store without starting the
+// daemon.
+func runStoreCommand(args []string) {
+	if len(args) == 0 || args[0] != "migrate" {
+		fmt.Fprintln(os.Stderr, "usage: wings store migrate -to driver [-from driver] [-config path]")
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("store migrate", flag.ExitOnError)
+	path := fs.String("config", "config.yml", "set the location for the configuration file")
+	from := fs.String("from", "", "the store to copy servers from, defaults to the store in the configuration file")
+	to := fs.String("to", "", "the store to copy servers to")
+	fs.Parse(args[1:])
+
+	// Problems that would only be warned about when the daemon boots do not prevent the
+	// store from being migrated.
+	c, err := config.ReadConfiguration(*path)
+	if err != nil {
+		printConfigurationError(*path, err)
+		if !config.IsValidationError(err) {
+			os.Exit(1)
+		}
+	}
+
+	if *from == "" {
+		*from = c.System.Store.Driver
+	}
+
+	if *to == "" || *to == *from {
+		fmt.Fprintln(os.Stderr, "a store different to the one being migrated from must be provided using -to")
+		os.Exit(2)
+	}
+
+	n, err := migrateStore(c.System.Store.Root, *from, *to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to migrate servers from the %s store to the %s store: %s\n", *from, *to, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("migrated %d server(s) from the %s store to the %s store\n", n, *from, *to)
+	fmt.Printf("set system.store.driver to \"%s\" in %s to start using it\n", *to, *path)
+}
+
+// Copies every server definition from one store to another, both using the same root
+// directory. The source store is left as it is so that it can be switched back to if
+// needed. This must not be run while the daemon is running, since any changes it makes
+// to the source store during the migration would be lost.
+func migrateStore(root string, from string, to string) (int, error) {
+	src, err := server.OpenStore(from, root)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	dst, err := server.OpenStore(to, root)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	uuids, err := src.List()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, uuid := range uuids {
+		b, err := src.Get(uuid)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := dst.Put(uuid, b); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(uuids), nil
+}
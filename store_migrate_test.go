@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"github.com/pterodactyl/wings/server"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMigrateStoreFromYamlToKV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wings-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	definitions := map[string][]byte{
+		"0f2d5cf6-7bd4-4b0e-9a4c-8f4c5e1a2b3c": []byte("uuid: 0f2d5cf6-7bd4-4b0e-9a4c-8f4c5e1a2b3c\n"),
+		"5a1b0c2d-3e4f-4a5b-8c6d-7e8f9a0b1c2d": []byte("uuid: 5a1b0c2d-3e4f-4a5b-8c6d-7e8f9a0b1c2d\n"),
+	}
+
+	ys, err := server.NewYamlStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for uuid, b := range definitions {
+		if err := ys.Put(uuid, b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := migrateStore(dir, "yaml", "kv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != len(definitions) {
+		t.Fatalf("expected %d servers to be migrated, got %d", len(definitions), n)
+	}
+
+	kv, err := server.NewKVStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kv.Close()
+
+	uuids, err := kv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(uuids) != len(definitions) {
+		t.Fatalf("expected the key-value store to contain %d servers, got %v", len(definitions), uuids)
+	}
+
+	for uuid, expected := range definitions {
+		b, err := kv.Get(uuid)
+		if err != nil {
+			t.Fatalf("expected %s to be migrated: %s", uuid, err)
+		}
+
+		if !bytes.Equal(b, expected) {
+			t.Fatalf("expected the definition for %s to be %q, got %q", uuid, expected, b)
+		}
+
+		// The source store is left as it is so that the daemon can be switched back to it.
+		if _, err := ys.Get(uuid); err != nil {
+			t.Fatalf("expected %s to still be in the YAML store: %s", uuid, err)
+		}
+	}
+}
+
+func TestMigrateStoreWithUnknownDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wings-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := migrateStore(dir, "yaml", "unknown"); err == nil {
+		t.Fatal("expected migrating to an unknown store driver to fail")
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/atomicfile"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The keys that tokens can be signed with, built from the configuration they were created
+// with so that they can be recreated if the keys are changed when reloading it.
+var keys struct {
+	sync.Mutex
+	cfg     *config.Configuration
+	primary *jwt.HMACSHA
+	byId    map[string]*jwt.HMACSHA
+}
+
+// Returns the primary signing key and the additional keys identified by their ID for the
+// current configuration.
+func getSigningKeys() (*jwt.HMACSHA, map[string]*jwt.HMACSHA) {
+	keys.Lock()
+	defer keys.Unlock()
+
+	if c := config.Get(); keys.cfg != c {
+		keys.cfg = c
+		keys.primary = jwt.NewHS256([]byte(c.AuthenticationToken))
+		keys.byId = make(map[string]*jwt.HMACSHA, len(c.Tokens.SigningKeys))
+
+		for _, k := range c.Tokens.SigningKeys {
+			keys.byId[k.Id] = jwt.NewHS256([]byte(k.Secret))
+		}
+	}
+
+	return keys.primary, keys.byId
+}
+
+// Verifies a token using the signing key identified by the "kid" header of the token, or
+// the primary key if the token does not have one. A new instance must be used for each
+// token since the key is selected when the header is resolved.
+type keyring struct {
+	primary *jwt.HMACSHA
+	byId    map[string]*jwt.HMACSHA
+	key     *jwt.HMACSHA
+}
+
+func newKeyring() *keyring {
+	primary, byId := getSigningKeys()
+
+	return &keyring{primary: primary, byId: byId}
+}
+
+func (k *keyring) Resolve(h jwt.Header) error {
+	if h.KeyID == "" {
+		k.key = k.primary
+		return nil
+	}
+
+	key, ok := k.byId[h.KeyID]
+	if !ok {
+		return errors.New("token is signed with an unknown key")
+	}
+
+	k.key = key
+
+	return nil
+}
+
+func (k *keyring) Name() string {
+	return k.primary.Name()
+}
+
+func (k *keyring) Sign(headerPayload []byte) ([]byte, error) {
+	return nil, errors.New("keyring cannot be used to sign tokens")
+}
+
+func (k *keyring) Size() int {
+	return k.primary.Size()
+}
+
+func (k *keyring) Verify(headerPayload, sig []byte) error {
+	if k.key == nil {
+		return errors.New("token signing key has not been resolved")
+	}
+
+	return k.key.Verify(headerPayload, sig)
+}
+
+// Validates the signature and claims of a token issued by the Panel for a server and
+// returns the parsed data. The same tokens are used for the websocket and for server routes
+// on the API.
+//
+// The expiration, not before and issued at times are checked allowing for the configured
+// clock skew, as are the issuer and audience if they are configured. Tokens that have
+// been revoked by the Panel are rejected.
+func ParseToken(token []byte) (*WebsocketTokenPayload, error) {
+	var payload WebsocketTokenPayload
+
+	cfg := config.Get().Tokens
+	now := time.Now()
+	skew := tokenClockSkew()
+
+	validators := []jwt.Validator{
+		jwt.ExpirationTimeValidator(now.Add(-skew)),
+		jwt.NotBeforeValidator(now.Add(skew)),
+		jwt.IssuedAtValidator(now.Add(skew)),
+	}
+
+	if cfg.Issuer != "" {
+		validators = append(validators, jwt.IssuerValidator(cfg.Issuer))
+	}
+
+	if cfg.Audience != "" {
+		validators = append(validators, jwt.AudienceValidator(jwt.Audience{cfg.Audience}))
+	}
+
+	_, err := jwt.Verify(token, newKeyring(), &payload, jwt.ValidateHeader, jwt.ValidatePayload(&payload.Payload, validators...))
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked.IsRevoked(&payload) {
+		return nil, errors.New("token has been revoked")
+	}
+
+	return &payload, nil
+}
+
+// Returns the amount of time that the clocks of the Panel and this daemon are allowed to
+// differ by when checking the times included in a token.
+func tokenClockSkew() time.Duration {
+	return time.Second * time.Duration(config.Get().Tokens.ClockSkew)
+}
+
+// Returns the file that token revocations are persisted to, so that revoked tokens do not
+// become valid again when the daemon is restarted. This is kept alongside the servers in
+// the store root.
+func revocationsPath() string {
+	return filepath.Join(config.Get().System.Store.Root, "revocations.json")
+}
+
+// Tracks the tokens that have been revoked by the Panel, either individually using their
+// ID, or for a user, in which case every token issued to the user before the revocation is
+// rejected. Each revocation is stored along with the time it was made.
+type revocations struct {
+	sync.RWMutex
+	Tokens map[string]time.Time `json:"tokens"`
+	Users  map[string]time.Time `json:"users"`
+}
+
+var revoked = &revocations{
+	Tokens: make(map[string]time.Time),
+	Users:  make(map[string]time.Time),
+}
+
+// Determines if a token has been revoked.
+func (r *revocations) IsRevoked(p *WebsocketTokenPayload) bool {
+	r.RLock()
+	defer r.RUnlock()
+
+	if _, ok := r.Tokens[p.JWTID]; ok && p.JWTID != "" {
+		return true
+	}
+
+	if t, ok := r.Users[p.UserID.String()]; ok && p.UserID != "" {
+		// Tokens without an issued at time cannot be compared aganist the time of the
+		// revocation, so they are always rejected.
+		return p.IssuedAt == nil || !p.IssuedAt.After(t)
+	}
+
+	return false
+}
+
+// Revokes the tokens with the given IDs, and all of the tokens issued to the given users
+// up until now. Revocations older than the configured TTL are removed, and the result is
+// persisted to the disk.
+func (r *revocations) Revoke(tokens []string, users []string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	for _, t := range tokens {
+		r.Tokens[t] = now
+	}
+
+	for _, u := range users {
+		r.Users[u] = now
+	}
+
+	r.prune(now)
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return atomicfile.WriteFile(revocationsPath(), b, 0600)
+}
+
+// Removes the revocations that are older than the configured TTL. Tokens affected by them
+// have expired by this point.
+func (r *revocations) prune(now time.Time) {
+	cutoff := now.Add(-time.Second * time.Duration(config.Get().Tokens.RevocationTTL))
+
+	for k, t := range r.Tokens {
+		if t.Before(cutoff) {
+			delete(r.Tokens, k)
+		}
+	}
+
+	for k, t := range r.Users {
+		if t.Before(cutoff) {
+			delete(r.Users, k)
+		}
+	}
+}
+
+// Loads the persisted token revocations from the disk.
+func loadRevocations() error {
+	b, err := ioutil.ReadFile(revocationsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.WithStack(err)
+	}
+
+	revoked.Lock()
+	defer revoked.Unlock()
+
+	if err := json.Unmarshal(b, revoked); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if revoked.Tokens == nil {
+		revoked.Tokens = make(map[string]time.Time)
+	}
+
+	if revoked.Users == nil {
+		revoked.Users = make(map[string]time.Time)
+	}
+
+	revoked.prune(time.Now())
+
+	zap.S().Debugw("loaded token revocations", zap.Int("tokens", len(revoked.Tokens)), zap.Int("users", len(revoked.Users)))
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/gbrlsnchs/jwt/v3"
@@ -8,11 +9,9 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
-	"github.com/pterodactyl/wings/config"
 	"github.com/pterodactyl/wings/server"
 	"go.uber.org/zap"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 	"time"
@@ -29,6 +28,10 @@ const (
 	ErrorEvent                 = "daemon error"
 )
 
+// The amount of time that a connection is kept open after the token used to authenticate
+// it has expired, giving the client a chance to send a new one before it is closed.
+const tokenExpiredGracePeriod = time.Minute
+
 type WebsocketMessage struct {
 	// The event to perform. Should be one of the following that are supported:
 	//
@@ -42,6 +45,15 @@ type WebsocketMessage struct {
 	// should either omit the field or pass an empty value as it is ignored.
 	Args []string `json:"args,omitempty"`
 
+	// The sequence number of the line for console output sent to the client. Clients can
+	// pass the last sequence number they received in this field when sending the logs event
+	// after they reconnect to receive only the output they missed.
+	//
+	// Output keeps being delivered while the history is replayed, so lines from the replay
+	// can arrive after newer lines. A line is never sent twice over a connection, clients
+	// should order the output they receive by this field.
+	Sequence uint64 `json:"seq,omitempty"`
+
 	// Is set to true when the request is originating from outside of the Daemon,
 	// otherwise set to false for outbound.
 	inbound bool
@@ -51,7 +63,48 @@ type WebsocketHandler struct {
 	Server     *server.Server
 	Mutex      sync.Mutex
 	Connection *websocket.Conn
-	JWT        *WebsocketTokenPayload
+
+	// The router that accepted the connection, used to track power actions started over the
+	// socket so that they are allowed to finish when the daemon is shutting down.
+	router *Router
+
+	// The token that the connection was authenticated with. It is replaced whenever the
+	// client sends a new one, so it must only be accessed through GetJWT.
+	jwt      *WebsocketTokenPayload
+	jwtMutex sync.RWMutex
+
+	// Set when the connection has been closed by the daemon, rather than the client.
+	closed bool
+
+	// The console output that has been sent over the connection. The mutex is held while
+	// the history is replayed so that live output is not sent in the middle of it.
+	console      consoleSequence
+	consoleMutex sync.Mutex
+}
+
+// Tracks the range of console output sequence numbers that have been sent over a
+// connection. Live output only ever comes after the lines already sent, and a replay
+// starts from the last line the client received, so the lines the client has are
+// covered by a single range.
+type consoleSequence struct {
+	low  uint64
+	high uint64
+}
+
+// Determines if the line with the given sequence number has already been sent.
+func (c *consoleSequence) sent(sequence uint64) bool {
+	return c.high > 0 && sequence >= c.low && sequence <= c.high
+}
+
+// Records that the line with the given sequence number has been sent.
+func (c *consoleSequence) add(sequence uint64) {
+	if c.high == 0 || sequence < c.low {
+		c.low = sequence
+	}
+
+	if sequence > c.high {
+		c.high = sequence
+	}
 }
 
 type WebsocketTokenPayload struct {
@@ -61,18 +114,10 @@ type WebsocketTokenPayload struct {
 	Permissions []string    `json:"permissions"`
 }
 
-const (
-	PermissionConnect        = "connect"
-	PermissionSendCommand    = "send-command"
-	PermissionSendPower      = "send-power"
-	PermissionReceiveErrors  = "receive-errors"
-	PermissionReceiveInstall = "receive-install"
-)
-
-// Checks if the given token payload has a permission string.
-func (wtp *WebsocketTokenPayload) HasPermission(permission string) bool {
+// Checks if the given token payload grants a permission.
+func (wtp *WebsocketTokenPayload) HasPermission(permission Permission) bool {
 	for _, k := range wtp.Permissions {
-		if k == permission {
+		if permission.GrantedBy(k) {
 			return true
 		}
 	}
@@ -80,58 +125,126 @@ func (wtp *WebsocketTokenPayload) HasPermission(permission string) bool {
 	return false
 }
 
-var alg *jwt.HMACSHA
-
 // Validates the provided JWT against the known secret for the Daemon and returns the
 // parsed data.
 //
 // This function DOES NOT validate that the token is valid for the connected server, nor
 // does it ensure that the user providing the token is able to actually do things.
 func ParseJWT(token []byte) (*WebsocketTokenPayload, error) {
-	var payload WebsocketTokenPayload
-	if alg == nil {
-		alg = jwt.NewHS256([]byte(config.Get().AuthenticationToken))
-	}
-
-	now := time.Now()
-	verifyOptions := jwt.ValidatePayload(
-		&payload.Payload,
-		jwt.ExpirationTimeValidator(now),
-	)
-
-	_, err := jwt.Verify(token, alg, &payload, verifyOptions)
+	payload, err := ParseToken(token)
 	if err != nil {
 		return nil, err
 	}
 
-	if !payload.HasPermission(PermissionConnect) {
+	if !payload.HasPermission(PermissionWebsocketConnect) {
 		return nil, errors.New("not authorized to connect to this socket")
 	}
 
-	return &payload, nil
+	return payload, nil
+}
+
+// Returns the token that the connection is currently authenticated with, or nil if the
+// client has not authenticated yet.
+func (wsh *WebsocketHandler) GetJWT() *WebsocketTokenPayload {
+	wsh.jwtMutex.RLock()
+	defer wsh.jwtMutex.RUnlock()
+
+	return wsh.jwt
+}
+
+// Authenticates the connection using a new token. Once a connection has been authenticated
+// the token can be refreshed without reconnecting, but only with a token for the same user.
+// Any changes to the permissions of the new token apply immediately.
+func (wsh *WebsocketHandler) setJWT(token *WebsocketTokenPayload) error {
+	if wsh.Server.Uuid != token.ServerUUID {
+		return errors.New("jwt server uuid mismatch")
+	}
+
+	wsh.jwtMutex.Lock()
+	defer wsh.jwtMutex.Unlock()
+
+	if wsh.jwt != nil && wsh.jwt.UserID != token.UserID {
+		return errors.New("jwt user does not match the authenticated user")
+	}
+
+	wsh.jwt = token
+
+	return nil
 }
 
 // Checks if the JWT is still valid.
 func (wsh *WebsocketHandler) TokenValid() error {
-	if wsh.JWT == nil {
+	j := wsh.GetJWT()
+	if j == nil {
 		return errors.New("no jwt present")
 	}
 
-	if err := jwt.ExpirationTimeValidator(time.Now())(&wsh.JWT.Payload); err != nil {
+	// The same clock skew is allowed as when the token was first parsed, otherwise a token
+	// accepted close to its expiration would be treated as expired straight away.
+	if err := jwt.ExpirationTimeValidator(time.Now().Add(-tokenClockSkew()))(&j.Payload); err != nil {
 		return err
 	}
 
-	if !wsh.JWT.HasPermission(PermissionConnect) {
+	if !j.HasPermission(PermissionWebsocketConnect) {
 		return errors.New("jwt does not have connect permission")
 	}
 
-	if wsh.Server.Uuid != wsh.JWT.ServerUUID {
+	if revoked.IsRevoked(j) {
+		return errors.New("jwt has been revoked")
+	}
+
+	if wsh.Server.Uuid != j.ServerUUID {
 		return errors.New("jwt server uuid mismatch")
 	}
 
 	return nil
 }
 
+// Sends a notice over the socket if the token is within 3 minutes of expiring, or if it
+// has expired. If the token expired more than the grace period ago without the client
+// sending a new one the connection is closed, rather than leaving it open and silently
+// dropping everything that would be sent over it.
+func (wsh *WebsocketHandler) checkTokenExpiration() {
+	j := wsh.GetJWT()
+	if j == nil || j.ExpirationTime == nil {
+		return
+	}
+
+	remaining := time.Until(j.ExpirationTime.Time)
+	switch {
+	case remaining <= -tokenExpiredGracePeriod:
+		wsh.closeWithReason(websocket.ClosePolicyViolation, "authentication token has expired")
+	case remaining <= 0:
+		wsh.unsafeSendJson(WebsocketMessage{Event: TokenExpiredEvent})
+	case remaining <= time.Minute*3:
+		wsh.unsafeSendJson(WebsocketMessage{Event: TokenExpiringEvent})
+	}
+}
+
+// Closes the connection from the daemon, sending the client a close message with the
+// given code and reason.
+func (wsh *WebsocketHandler) closeWithReason(code int, reason string) {
+	wsh.jwtMutex.Lock()
+	wsh.closed = true
+	wsh.jwtMutex.Unlock()
+
+	wsh.Connection.WriteControl(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(time.Second*5),
+	)
+
+	wsh.Connection.Close()
+}
+
+// Determines if the connection was closed by the daemon.
+func (wsh *WebsocketHandler) isClosed() bool {
+	wsh.jwtMutex.RLock()
+	defer wsh.jwtMutex.RUnlock()
+
+	return wsh.closed
+}
+
 // Handle a request for a specific server websocket. This will handle inbound requests as well
 // as ensure that any console output is also passed down the wire on the socket.
 func (rt *Router) routeWebsocket(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -143,6 +256,29 @@ func (rt *Router) routeWebsocket(w http.ResponseWriter, r *http.Request, ps http
 		return
 	}
 
+	s := rt.GetServer(ps.ByName("server"))
+	handler := WebsocketHandler{
+		Server:     s,
+		Mutex:      sync.Mutex{},
+		Connection: c,
+		router:     rt,
+	}
+
+	// Reject the connection if the daemon is in the process of shutting down, otherwise
+	// track it so that it can be closed cleanly when that happens.
+	if !rt.trackWebsocket(&handler) {
+		c.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseServiceRestart, "daemon is shutting down"),
+			time.Now().Add(time.Second*5),
+		)
+
+		c.Close()
+
+		return
+	}
+	defer rt.untrackWebsocket(&handler)
+
 	// Make a ticker and completion channel that is used to continuously poll the
 	// JWT stored in the session to send events to the socket when it is expiring.
 	ticker := time.NewTicker(time.Second * 30)
@@ -156,14 +292,6 @@ func (rt *Router) routeWebsocket(w http.ResponseWriter, r *http.Request, ps http
 		c.Close()
 	}()
 
-	s := rt.GetServer(ps.ByName("server"))
-	handler := WebsocketHandler{
-		Server:     s,
-		Mutex:      sync.Mutex{},
-		Connection: c,
-		JWT:        nil,
-	}
-
 	events := []string{
 		server.StatsEvent,
 		server.StatusEvent,
@@ -172,47 +300,42 @@ func (rt *Router) routeWebsocket(w http.ResponseWriter, r *http.Request, ps http
 		server.DaemonMessageEvent,
 	}
 
-	eventChannel := make(chan server.Event)
-	for _, event := range events {
-		s.Events().Subscribe(event, eventChannel)
-	}
-
-	defer func() {
-		for _, event := range events {
-			s.Events().Unsubscribe(event, eventChannel)
-		}
-
-		close(eventChannel)
-	}()
+	sub := s.Events().Subscribe(events...)
+	defer sub.Close()
 
 	// Listen for different events emitted by the server and respond to them appropriately.
 	go func() {
-		for d := range eventChannel {
+		for d := range sub.Events() {
+			if d.Topic == server.ConsoleOutputEvent {
+				handler.sendConsoleOutput(server.ConsoleLine{Sequence: d.Sequence, Line: d.Data})
+				continue
+			}
+
 			handler.SendJson(&WebsocketMessage{
 				Event: d.Topic,
 				Args:  []string{d.Data},
 			})
 		}
+
+		// The subscription is removed from the event bus if the connection is not keeping
+		// up with the events for the server. Close the connection so that the client knows
+		// to reconnect and replay the console output it missed.
+		if sub.Disconnected() {
+			zap.S().Debugw("closing websocket connection that is not keeping up with server events", zap.String("server", s.Uuid), zap.Uint64("dropped", sub.Dropped()))
+
+			handler.closeWithReason(websocket.CloseTryAgainLater, "connection is not keeping up with server events")
+		}
 	}()
-	// Sit here and check the time to expiration on the JWT every 30 seconds until
-	// the token has expired. If we are within 3 minutes of the token expiring, send
-	// a notice over the socket that it is expiring soon. If it has expired, send that
-	// notice as well.
+	// Sit here and check the time to expiration on the JWT every 30 seconds, sending
+	// notices over the socket as it nears expiration and closing the connection if it
+	// is not replaced in time.
 	go func() {
 		for {
 			select {
 			case <-done:
 				return
 			case <-ticker.C:
-				{
-					if handler.JWT != nil {
-						if handler.JWT.ExpirationTime.Unix()-time.Now().Unix() <= 0 {
-							handler.SendJson(&WebsocketMessage{Event: TokenExpiredEvent})
-						} else if handler.JWT.ExpirationTime.Unix()-time.Now().Unix() <= 180 {
-							handler.SendJson(&WebsocketMessage{Event: TokenExpiringEvent})
-						}
-					}
-				}
+				handler.checkTokenExpiration()
 			}
 		}
 	}()
@@ -222,7 +345,7 @@ func (rt *Router) routeWebsocket(w http.ResponseWriter, r *http.Request, ps http
 
 		_, p, err := c.ReadMessage()
 		if err != nil {
-			if !websocket.IsCloseError(
+			if !rt.isClosing() && !handler.isClosed() && !websocket.IsCloseError(
 				err,
 				websocket.CloseNormalClosure,
 				websocket.CloseGoingAway,
@@ -258,18 +381,40 @@ func (wsh *WebsocketHandler) SendJson(v *WebsocketMessage) error {
 		return nil
 	}
 
-	// If we're sending installation output but the user does not have the required
-	// permissions to see the output, don't send it down the line.
-	if v.Event == server.InstallOutputEvent {
-		zap.S().Debugf("%+v", v.Args)
-		if wsh.JWT != nil && !wsh.JWT.HasPermission(PermissionReceiveInstall) {
-			return nil
-		}
+	// Don't send events down the line that the user does not have permission to see, such
+	// as the output from the installation process.
+	if p, ok := websocketOutboundPermissions[v.Event]; ok && !wsh.GetJWT().HasPermission(p) {
+		return nil
 	}
 
 	return wsh.unsafeSendJson(v)
 }
 
+// Sends lines of console output over the connection, skipping any that have already been
+// sent. Lines replayed from the history are passed together so that no live output is sent
+// in between them.
+func (wsh *WebsocketHandler) sendConsoleOutput(lines ...server.ConsoleLine) {
+	wsh.consoleMutex.Lock()
+	defer wsh.consoleMutex.Unlock()
+
+	// Lines are checked against what had been sent before this call, otherwise sending the
+	// first replayed line would cover everything between it and the live output.
+	previous := wsh.console
+	for _, l := range lines {
+		if previous.sent(l.Sequence) {
+			continue
+		}
+
+		wsh.SendJson(&WebsocketMessage{
+			Event:    server.ConsoleOutputEvent,
+			Args:     []string{l.Line},
+			Sequence: l.Sequence,
+		})
+
+		wsh.console.add(l.Sequence)
+	}
+}
+
 // Sends JSON over the websocket connection, ignoring the authentication state of the
 // socket user. Do not call this directly unless you are positive a response should be
 // sent back to the client!
@@ -281,15 +426,15 @@ func (wsh *WebsocketHandler) unsafeSendJson(v interface{}) error {
 }
 
 // Sends an error back to the connected websocket instance by checking the permissions
-// of the token. If the user has the "receive-errors" grant we will send back the actual
+// of the token. If the user has the "admin.errors" grant we will send back the actual
 // error message, otherwise we just send back a standard error message.
 func (wsh *WebsocketHandler) SendErrorJson(err error) error {
 	wsh.Mutex.Lock()
 	defer wsh.Mutex.Unlock()
 
 	message := "an unexpected error was encountered while handling this request"
-	if wsh.JWT != nil {
-		if server.IsSuspendedError(err) || wsh.JWT.HasPermission(PermissionReceiveErrors) {
+	if j := wsh.GetJWT(); j != nil {
+		if server.IsSuspendedError(err) || server.IsPowerLockedError(err) || j.HasPermission(PermissionAdminErrors) {
 			message = err.Error()
 		}
 	}
@@ -299,7 +444,7 @@ func (wsh *WebsocketHandler) SendErrorJson(err error) error {
 	wsm := WebsocketMessage{Event: ErrorEvent}
 	wsm.Args = []string{m}
 
-	if !server.IsSuspendedError(err) {
+	if !server.IsSuspendedError(err) && !server.IsPowerLockedError(err) {
 		zap.S().Errorw(
 			"an error was encountered in the websocket process",
 			zap.String("server", wsh.Server.Uuid),
@@ -340,6 +485,10 @@ func (wsh *WebsocketHandler) HandleInbound(m WebsocketMessage) error {
 		}
 	}
 
+	if p, ok := websocketPermission(m); ok && !wsh.GetJWT().HasPermission(p) {
+		return nil
+	}
+
 	switch m.Event {
 	case AuthenticationEvent:
 		{
@@ -348,13 +497,15 @@ func (wsh *WebsocketHandler) HandleInbound(m WebsocketMessage) error {
 				return err
 			}
 
-			if token.HasPermission(PermissionConnect) {
-				wsh.JWT = token
+			// A token for a different server or user is rejected, leaving the connection
+			// authenticated with the token it already had.
+			if err := wsh.setJWT(token); err != nil {
+				return err
 			}
 
 			// On every authentication event, send the current server status back
 			// to the client. :)
-			wsh.Server.Events().Publish(server.StatusEvent, wsh.Server.State)
+			wsh.Server.Events().Publish(server.StatusEvent, string(wsh.Server.GetState()))
 
 			wsh.unsafeSendJson(WebsocketMessage{
 				Event: AuthenticationSuccessEvent,
@@ -365,50 +516,49 @@ func (wsh *WebsocketHandler) HandleInbound(m WebsocketMessage) error {
 		}
 	case SetStateEvent:
 		{
-			if !wsh.JWT.HasPermission(PermissionSendPower) {
+			if len(m.Args) == 0 || !server.IsValidPowerAction(m.Args[0]) {
 				return nil
 			}
 
-			switch strings.Join(m.Args, "") {
-			case "start":
-				return wsh.Server.Environment.Start()
-			case "stop":
-				return wsh.Server.Environment.Stop()
-			case "restart":
-				return nil
-			case "kill":
-				return wsh.Server.Environment.Terminate(os.Kill)
+			// Passing "wait" as the second argument will queue this action behind any
+			// power action that is currently being processed, rather than rejecting it.
+			action, wait := m.Args[0], len(m.Args) > 1 && m.Args[1] == "wait"
+
+			// Power actions can take minutes to complete when waiting for the lock or for the
+			// server to stop, so they are processed in the background to keep the connection
+			// responsive to other messages, such as a token being refreshed. The changes in
+			// state are sent over the socket as status events, and any error is sent back as
+			// an error event.
+			ok := wsh.router.runTask(func() {
+				if err := wsh.Server.AcquirePowerLock(wait); err != nil {
+					wsh.SendErrorJson(err)
+					return
+				}
+				defer wsh.Server.ReleasePowerLock()
+
+				if err := wsh.Server.HandlePowerAction(context.Background(), action); err != nil {
+					wsh.SendErrorJson(err)
+				}
+			})
+
+			if !ok {
+				return errors.New(errShuttingDown)
 			}
 
 			return nil
 		}
 	case SendServerLogsEvent:
 		{
-			if running, _ := wsh.Server.Environment.IsRunning(); !running {
-				return nil
-			}
-
-			logs, err := wsh.Server.Environment.Readlog(1024 * 16)
-			if err != nil {
-				return err
-			}
-
-			for _, line := range logs {
-				wsh.SendJson(&WebsocketMessage{
-					Event: server.ConsoleOutputEvent,
-					Args:  []string{line},
-				})
-			}
+			// Clients can pass the sequence number of the last line of output they received
+			// to only have the lines after it replayed, otherwise the entire console history
+			// is sent.
+			wsh.sendConsoleOutput(wsh.Server.ConsoleHistory().Since(m.Sequence)...)
 
 			return nil
 		}
 	case SendCommandEvent:
 		{
-			if !wsh.JWT.HasPermission(PermissionSendCommand) {
-				return nil
-			}
-
-			if wsh.Server.State == server.ProcessOfflineState {
+			if wsh.Server.GetState() == server.ProcessOfflineState {
 				return nil
 			}
 
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/gorilla/websocket"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketMessageSequenceFromClient(t *testing.T) {
+	var m WebsocketMessage
+	if err := json.Unmarshal([]byte(`{"event":"send logs","seq":42}`), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Event != SendServerLogsEvent || m.Sequence != 42 {
+		t.Fatalf("expected a logs event for sequence 42, got %+v", m)
+	}
+}
+
+func TestConsoleOutputIsNotSentTwiceWhenReplayingHistory(t *testing.T) {
+	defer useTestConfiguration(t)()
+
+	line := func(sequence uint64) server.ConsoleLine {
+		return server.ConsoleLine{Sequence: sequence, Line: "line"}
+	}
+
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+
+		handler := WebsocketHandler{
+			Server:     &server.Server{Uuid: testServerUuid},
+			Connection: c,
+			jwt: &WebsocketTokenPayload{
+				Payload: jwt.Payload{
+					ExpirationTime: jwt.NumericDate(time.Now().Add(time.Minute)),
+				},
+				ServerUUID:  testServerUuid,
+				Permissions: []string{"*"},
+			},
+		}
+
+		// Live output arrives before the client asks for the history, and then the
+		// history is replayed while the next line is being published.
+		handler.sendConsoleOutput(line(4))
+		handler.sendConsoleOutput(line(5))
+		handler.sendConsoleOutput(line(2), line(3), line(4), line(5), line(6))
+		handler.sendConsoleOutput(line(6))
+		handler.sendConsoleOutput(line(7))
+	}))
+	defer srv.Close()
+
+	c, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var received []uint64
+	for {
+		var m WebsocketMessage
+		if err := c.ReadJSON(&m); err != nil {
+			break
+		}
+
+		received = append(received, m.Sequence)
+	}
+
+	expected := []uint64{4, 5, 2, 3, 6, 7}
+	if len(received) != len(expected) {
+		t.Fatalf("expected to receive lines %v, got %v", expected, received)
+	}
+
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("expected to receive lines %v, got %v", expected, received)
+		}
+	}
+}
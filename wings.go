@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -13,6 +14,8 @@ import (
 	"go.uber.org/zap"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 var configPath = "config.yml"
@@ -21,15 +24,28 @@ var debug = false
 // Entrypoint for the Wings application. Configures the logger and checks any
 // flags that were passed through in the boot arguments.
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "config":
+			runConfigCommand(os.Args[2:])
+			return
+		case "store":
+			runStoreCommand(os.Args[2:])
+			return
+		}
+	}
+
 	flag.StringVar(&configPath, "config", "config.yml", "set the location for the configuration file")
 	flag.BoolVar(&debug, "debug", false, "pass in order to run wings in debug mode")
 
 	flag.Parse()
 
+	// Unknown settings and invalid values are only reported once logging has been
+	// configured, use "wings config check" to treat them as fatal.
 	c, err := config.ReadConfiguration(configPath)
-	if err != nil {
-		panic(err)
-		return
+	if err != nil && !config.IsValidationError(err) {
+		printConfigurationError(configPath, err)
+		os.Exit(1)
 	}
 
 	if debug {
@@ -42,6 +58,7 @@ func main() {
 	}
 
 	zap.S().Infof("using configuration from path: %s", configPath)
+	logConfigurationProblems(err)
 	if c.Debug {
 		zap.S().Debugw("running in debug mode")
 		zap.S().Infow("certificate checking is disabled")
@@ -69,14 +86,32 @@ func main() {
 		zap.S().Infow("finished ensuring file permissions")
 	}
 
-	if err := server.LoadDirectory("data/servers", &c.System); err != nil {
+	store, err := server.OpenStore(c.System.Store.Driver, c.System.Store.Root)
+	if err != nil {
+		zap.S().Fatalw("failed to open server store", zap.String("driver", c.System.Store.Driver), zap.Error(errors.WithStack(err)))
+		return
+	}
+	server.SetStore(store)
+
+	if err := loadRevocations(); err != nil {
+		zap.S().Errorw("failed to load token revocations", zap.Error(err))
+	}
+
+	if err := server.LoadServers(&c.System); err != nil {
 		zap.S().Fatalw("failed to load server configurations", zap.Error(errors.WithStack(err)))
 		return
 	}
 
-	if err := ConfigureDockerEnvironment(&c.Docker); err != nil {
-		zap.S().Fatalw("failed to configure docker environment", zap.Error(errors.WithStack(err)))
-		os.Exit(1)
+	// Docker is only required when one of the servers runs in it, a host that only runs
+	// servers as plain processes can boot without it. Servers created in the Docker
+	// environment later on will fail to start until the network has been configured.
+	if err := ConfigureDockerEnvironment(context.Background(), &c.Docker); err != nil {
+		if usesDockerEnvironment() {
+			zap.S().Fatalw("failed to configure docker environment", zap.Error(errors.WithStack(err)))
+			os.Exit(1)
+		}
+
+		zap.S().Warnw("failed to configure docker environment, no loaded servers require it", zap.Error(err))
 	}
 
 	if err := c.WriteToDisk(); err != nil {
@@ -102,11 +137,11 @@ func main() {
 			// Create a server environment if none exists currently. This allows us to recover from Docker
 			// being reinstalled on the host system for example.
 			zap.S().Infow("ensuring envrionment exists", zap.String("server", s.Uuid))
-			if err := s.Environment.Create(); err != nil {
+			if err := s.Environment.Create(context.Background()); err != nil {
 				zap.S().Errorw("failed to create an environment for server", zap.String("server", s.Uuid), zap.Error(err))
 			}
 
-			r, err := s.Environment.IsRunning()
+			r, err := s.Environment.IsRunning(context.Background())
 			if err != nil {
 				zap.S().Errorw("error checking server environment status", zap.String("server", s.Uuid), zap.Error(err))
 			}
@@ -117,9 +152,9 @@ func main() {
 			//
 			// This will also validate that a server process is running if the last tracked state we have
 			// is that it was running, but we see that the container process is not currently running.
-			if r || (!r && (s.State == server.ProcessRunningState || s.State == server.ProcessStartingState)) {
+			if r || (!r && (s.GetState() == server.ProcessRunningState || s.GetState() == server.ProcessStartingState)) {
 				zap.S().Infow("detected server is running, re-attaching to process", zap.String("server", s.Uuid))
-				if err := s.Environment.Start(); err != nil {
+				if err := s.Environment.Start(context.Background()); err != nil {
 					zap.S().Warnw(
 						"failed to properly start server detected as already running",
 						zap.String("server", s.Uuid),
@@ -130,9 +165,22 @@ func main() {
 				return
 			}
 
+			// Servers that failed to install are left in that state until they are reinstalled.
+			if s.GetState() == server.ProcessInstallFailedState {
+				return
+			}
+
 			// Addresses potentially invalid data in the stored file that can cause Wings to lose
 			// track of what the actual server state is.
-			s.SetState(server.ProcessOfflineState)
+			if err := s.SetState(server.ProcessOfflineState, "no running server process found on boot"); err != nil {
+				zap.S().Warnw("failed to update server state on boot", zap.String("server", s.Uuid), zap.Error(err))
+			}
+
+			if s.Suspended {
+				if err := s.SetState(server.ProcessSuspendedState, "server is suspended"); err != nil {
+					zap.S().Warnw("failed to update server state on boot", zap.String("server", s.Uuid), zap.Error(err))
+				}
+			}
 		}(serv)
 	}
 
@@ -144,29 +192,92 @@ func main() {
 		sftp.Initialize(c)
 	}
 
-	r := &Router{
-		token: c.AuthenticationToken,
-		upgrader: websocket.Upgrader{
-			// Ensure that the websocket request is originating from the Panel itself,
-			// and not some other location.
-			CheckOrigin: func(r *http.Request) bool {
-				return r.Header.Get("Origin") == c.PanelLocation
-			},
+	r := NewRouter(websocket.Upgrader{
+		// Ensure that the websocket request is originating from the Panel itself,
+		// and not some other location.
+		CheckOrigin: func(r *http.Request) bool {
+			return r.Header.Get("Origin") == config.Get().PanelLocation
 		},
-	}
+	})
+
+	// Power actions that servers start on their own are tracked by the router so that they
+	// are waited on when the daemon shuts down.
+	server.SetTaskRunner(r.runServerTask)
 
 	router := r.ConfigureRouter()
 	zap.S().Infow("configuring webserver", zap.Bool("ssl", c.Api.Ssl.Enabled), zap.String("host", c.Api.Host), zap.Int("port", c.Api.Port))
 
-	addr := fmt.Sprintf("%s:%d", c.Api.Host, c.Api.Port)
-	if c.Api.Ssl.Enabled {
-		if err := http.ListenAndServeTLS(addr, c.Api.Ssl.CertificateFile, c.Api.Ssl.KeyFile, router); err != nil {
-			zap.S().Fatalw("failed to configure HTTPS server", zap.Error(err))
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", c.Api.Host, c.Api.Port),
+		Handler: router,
+	}
+
+	go func() {
+		if c.Api.Ssl.Enabled {
+			if err := srv.ListenAndServeTLS(c.Api.Ssl.CertificateFile, c.Api.Ssl.KeyFile); err != nil && err != http.ErrServerClosed {
+				zap.S().Fatalw("failed to configure HTTPS server", zap.Error(err))
+			}
+		} else {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				zap.S().Fatalw("failed to configure HTTP server", zap.Error(err))
+			}
 		}
-	} else {
-		if err := http.ListenAndServe(addr, router); err != nil {
-			zap.S().Fatalw("failed to configure HTTP server", zap.Error(err))
+	}()
+
+	// Reload the configuration from the disk whenever the daemon receives a hangup signal.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			reloadConfiguration()
 		}
+	}()
+
+	// Block until the daemon is asked to stop, and then shut everything down cleanly. A
+	// second signal while shutting down exits immediately.
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	zap.S().Infow("received signal, shutting down the daemon", zap.String("signal", (<-sig).String()))
+
+	go func() {
+		zap.S().Warnw("received second signal, exiting immediately", zap.String("signal", (<-sig).String()))
+		os.Exit(1)
+	}()
+
+	shutdown(srv, r)
+}
+
+// Reloads the configuration file from the disk, replacing the global configuration if it
+// is valid. Returns the settings that were changed but require the daemon to be restarted
+// before they take effect.
+func reloadConfiguration() ([]string, error) {
+	restart, err := config.Reload(configPath)
+	if err != nil {
+		logConfigurationProblems(err)
+		zap.S().Errorw("failed to reload configuration, continuing to use the current configuration", zap.String("path", configPath), zap.Error(err))
+		return nil, err
+	}
+
+	zap.S().Infow("reloaded configuration from disk", zap.String("path", configPath))
+	if len(restart) > 0 {
+		zap.S().Warnw("some configuration changes will not be applied until the daemon is restarted", zap.Strings("settings", restart))
+	}
+
+	return restart, nil
+}
+
+// Logs a warning for each problem found when validating the configuration file. Anything
+// other than a ValidationError is ignored.
+func logConfigurationProblems(err error) {
+	verr, ok := err.(*config.ValidationError)
+	if !ok {
+		return
+	}
+
+	for _, p := range verr.Problems {
+		zap.S().Warnw("problem found in configuration file", zap.String("path", configPath), zap.String("setting", p.Path), zap.String("problem", p.Message))
 	}
 }
 